@@ -0,0 +1,116 @@
+// Command ingest receives the messages of the devices and writes them to
+// MySQL, so the devices don't hold database credentials. Point the angostura
+// sink of the devices at its /events endpoint, with the token of each device
+// as ANGOSTURA_BEARER_TOKEN.
+//
+// `ingest token <device ID>` prints a new token for a device and the line to
+// add to the tokens file.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/code-for-venezuela/poweroutage/pkg/ingest"
+	"github.com/code-for-venezuela/poweroutage/pkg/store"
+	"github.com/spf13/viper"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type Config struct {
+	Addr            string        `mapstructure:"ADDR"`
+	TokensFile      string        `mapstructure:"TOKENS_FILE"`
+	VerifySchema    bool          `mapstructure:"VERIFY_SCHEMA"`
+	ShutdownTimeout time.Duration `mapstructure:"SHUTDOWN_TIMEOUT"`
+}
+
+func main() {
+	flag.Parse()
+	if flag.Arg(0) == "token" {
+		if err := printToken(flag.Arg(1)); err != nil {
+			log.Fatalf("failed to create a token: %v", err)
+		}
+		return
+	}
+	config := loadConfig()
+
+	tokens, err := ingest.LoadTokens(config.TokensFile)
+	if err != nil {
+		log.Fatalf("failed to load the device tokens: %v", err)
+	}
+	log.Infof("Loaded the tokens of %v devices", tokens.Len())
+
+	dsn := os.Getenv("MYSQL_DSN")
+	if dsn == "" {
+		log.Fatalf("MYSQL_DSN environment variable is not set")
+	}
+	publisher, err := store.NewMySQLPublisher(dsn, config.VerifySchema)
+	if err != nil {
+		log.Fatalf("error creating MySQLPublisher: %v", err)
+	}
+	defer publisher.Close()
+
+	server := &http.Server{
+		Addr:              config.Addr,
+		Handler:           ingest.NewServer(publisher, tokens).Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+	go func() {
+		log.Infof("Listening on %v", config.Addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("failed to serve: %v", err)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+	log.Infof("Shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Errorf("failed to shut down cleanly: %v", err)
+	}
+}
+
+// printToken prints a new token for device, to configure on the device, and
+// its line in the tokens file.
+func printToken(device string) error {
+	if device == "" {
+		return fmt.Errorf("usage: ingest token <device ID>")
+	}
+	token, err := ingest.NewToken()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Token of %v: %v\n", device, token)
+	fmt.Printf("Line of the tokens file:\n%v %v\n", device, ingest.HashToken(token))
+	return nil
+}
+
+// loadConfig reads the configuration from the INGEST_ environment variables.
+func loadConfig() Config {
+	viper.SetEnvPrefix("ingest")
+	viper.AutomaticEnv()
+
+	viper.SetDefault("ADDR", ":8080")
+	viper.SetDefault("TOKENS_FILE", "/etc/c4v/ingest/tokens")
+	viper.SetDefault("VERIFY_SCHEMA", true)
+	viper.SetDefault("SHUTDOWN_TIMEOUT", "10s")
+
+	var config Config
+	if err := viper.Unmarshal(&config); err != nil {
+		log.Fatalf("failed to unmarshal config: %v", err)
+	}
+	return config
+}
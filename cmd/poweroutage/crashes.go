@@ -0,0 +1,85 @@
+package main
+
+import (
+	"github.com/code-for-venezuela/poweroutage/pkg/bootjournal"
+	"github.com/code-for-venezuela/poweroutage/pkg/store"
+	"github.com/code-for-venezuela/poweroutage/pkg/ups"
+)
+
+// crashRecordingPublisher records in the boot journal that the publisher
+// crashed the monitor, so safe mode turns it off first.
+type crashRecordingPublisher struct {
+	publisher store.Publisher
+	journal   *bootjournal.Journal
+}
+
+func (p crashRecordingPublisher) Publish(eventType string, payload []byte) error {
+	defer p.journal.RecordPanic(bootjournal.Publisher)
+	return p.publisher.Publish(eventType, payload)
+}
+
+func (p crashRecordingPublisher) PublishOutageEvent(event store.OutageEvent) error {
+	defer p.journal.RecordPanic(bootjournal.Publisher)
+	return p.publisher.PublishOutageEvent(event)
+}
+
+func (p crashRecordingPublisher) Close() error {
+	defer p.journal.RecordPanic(bootjournal.Publisher)
+	return p.publisher.Close()
+}
+
+// crashRecordingSensor records in the boot journal that the power sensor
+// crashed the monitor, so safe mode turns it off first.
+type crashRecordingSensor struct {
+	sensor  ups.PowerSensor
+	journal *bootjournal.Journal
+}
+
+// recordSensorCrashes wraps sensor, keeping it a ups.Reinitializer if it is
+// one.
+func recordSensorCrashes(sensor ups.PowerSensor, journal *bootjournal.Journal) ups.PowerSensor {
+	wrapped := crashRecordingSensor{sensor: sensor, journal: journal}
+	if _, ok := sensor.(ups.Reinitializer); ok {
+		return crashRecordingReinitializer{wrapped}
+	}
+	return wrapped
+}
+
+func (s crashRecordingSensor) GetBusVoltage_V() (float32, error) {
+	defer s.journal.RecordPanic(bootjournal.Sensor)
+	return s.sensor.GetBusVoltage_V()
+}
+
+func (s crashRecordingSensor) GetShuntVoltage_mV() (float32, error) {
+	defer s.journal.RecordPanic(bootjournal.Sensor)
+	return s.sensor.GetShuntVoltage_mV()
+}
+
+func (s crashRecordingSensor) GetCurrent_mA() (float32, error) {
+	defer s.journal.RecordPanic(bootjournal.Sensor)
+	return s.sensor.GetCurrent_mA()
+}
+
+func (s crashRecordingSensor) GetPower_W() (float32, error) {
+	defer s.journal.RecordPanic(bootjournal.Sensor)
+	return s.sensor.GetPower_W()
+}
+
+func (s crashRecordingSensor) Health() error {
+	defer s.journal.RecordPanic(bootjournal.Sensor)
+	return s.sensor.Health()
+}
+
+func (s crashRecordingSensor) Close() error {
+	defer s.journal.RecordPanic(bootjournal.Sensor)
+	return s.sensor.Close()
+}
+
+type crashRecordingReinitializer struct {
+	crashRecordingSensor
+}
+
+func (s crashRecordingReinitializer) Reinitialize() error {
+	defer s.journal.RecordPanic(bootjournal.Sensor)
+	return s.sensor.(ups.Reinitializer).Reinitialize()
+}
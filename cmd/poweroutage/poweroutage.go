@@ -2,18 +2,28 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
-	"encoding/json"
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	balenarerebooter "github.com/code-for-venezuela/poweroutage/pkg/balenarebooter"
-	"github.com/code-for-venezuela/poweroutage/pkg/eventsreader"
+	"github.com/code-for-venezuela/poweroutage/pkg/battery"
+	"github.com/code-for-venezuela/poweroutage/pkg/bootjournal"
 	"github.com/code-for-venezuela/poweroutage/pkg/eventsyncer"
+	"github.com/code-for-venezuela/poweroutage/pkg/monitor"
+	"github.com/code-for-venezuela/poweroutage/pkg/outagedetector"
+	"github.com/code-for-venezuela/poweroutage/pkg/outbox"
 	"github.com/code-for-venezuela/poweroutage/pkg/store"
+	"github.com/code-for-venezuela/poweroutage/pkg/telemetry"
 	"github.com/code-for-venezuela/poweroutage/pkg/ups"
 	"github.com/code-for-venezuela/poweroutage/pkg/util"
 	"github.com/spf13/viper"
@@ -25,8 +35,14 @@ func main() {
 
 	// Parse command line arguments
 	flag.Parse()
+	if flag.Arg(0) == "migrate" {
+		if err := migrate(os.Getenv("MYSQL_DSN")); err != nil {
+			log.Fatalf("failed to migrate the database: %v", err)
+		}
+		return
+	}
 	config := loadConfig()
-	log.Infof("This is the config: %+v", config)
+	log.Infof("This is the config: %+v", config.redacted())
 
 	customFormatter := new(log.TextFormatter)
 	customFormatter.TimestampFormat = "2006-01-02 15:04:05"
@@ -53,9 +69,11 @@ func main() {
 		config.Parish,
 		config.MonitorID)
 
-	upsManager := ups.NewManager()
-
-	defer upsManager.Close()
+	estimator, err := newBatteryEstimator(config)
+	if err != nil {
+		log.Fatalf("invalid battery configuration: %v", err)
+	}
+	log.Infof("Estimating the battery charge of a %vS %v pack", estimator.Cells, estimator.Chemistry)
 
 	eventsRecorder, err := store.NewFileSystemRecorder(
 		config.MonitorID,
@@ -66,31 +84,67 @@ func main() {
 		panic("can't initialize new filesystem recorder")
 	}
 
-	dsn := os.Getenv("MYSQL_DSN")
-	if dsn == "" {
-		fmt.Errorf("MYSQL_DSN environment variable is not set")
-		return
-	}
-	publisher, err := store.NewMySQLPublisher(dsn)
+	sinks, err := newSinks(config)
 	if err != nil {
-		fmt.Printf("Error creating MySQLPublisher: %v\n", err)
+		log.Errorf("unable to initialize the publisher: %v", err)
 		return
 	}
-	defer publisher.Close()
 
-	syncManager := eventsyncer.NewEventSyncer(1*time.Minute, eventsRecorder, publisher)
-	defer syncManager.Close()
-	go syncManager.Run(context.Background())
+	version := executableVersion()
+	journal, boot := startBootJournal(config, version, eventsRecorder)
+	defer func() {
+		if r := recover(); r != nil {
+			recordStop(journal, bootjournal.Crash, fmt.Sprint(r))
+			panic(r)
+		}
+	}()
+	safeMode := checkCrashLoop(journal, config)
 
-	var event *store.OutageEvent
-	event, err = eventsRecorder.GetMostRecentEvent()
+	if safeMode.Disables(bootjournal.Publisher) {
+		log.Warnf("Publishing is disabled in safe mode, events are kept on disk")
+	}
+	publisher, queues := newPublisher(config, sinks, journal, safeMode.Disables(bootjournal.Publisher))
+	defer publisher.Close()
+	if !safeMode.Disables(bootjournal.Publisher) && journal != nil {
+		boot.DeviceID = config.MonitorID
+		if err := bootjournal.PublishBoot(publisher, boot); err != nil {
+			log.Errorf("failed to publish boot event: %v", err)
+		}
+	}
 
-	if err == nil {
-		log.Infof("warning, there is already an ongoing event. It started at: %v", event.StartTime)
+	var sensor ups.PowerSensor
+	if safeMode.Disables(bootjournal.Sensor) {
+		log.Warnf("The power sensor is disabled in safe mode")
+		sensor = ups.DisabledSensor{Reason: "safe mode after a crash loop"}
+	} else {
+		sensor, err = newPowerSensor(config)
+		if err != nil {
+			// The monitor reinitializes the sensor until it can be opened.
+			log.Errorf("unable to initialize power sensor, will retry: %v", err)
+			sensor = ups.NewReopeningSensor(func() (ups.PowerSensor, error) {
+				return newPowerSensor(config)
+			}, err)
+		}
+		sensor = recordSensorCrashes(sensor, journal)
 	}
+	defer sensor.Close()
 
-	if err != nil && !strings.Contains(err.Error(), "no outage events recorded") {
-		log.Fatalf("unexpected error reading most recent event: %v", err)
+	// Balena sends SIGTERM before stopping the container, e.g. on updates.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	syncDone := make(chan struct{})
+	if safeMode.Disables(bootjournal.Publisher) {
+		close(syncDone)
+	} else {
+		syncManager := eventsyncer.NewEventSyncer(1*time.Minute, eventsRecorder, publisher)
+		defer syncManager.Close()
+		go func() {
+			defer close(syncDone)
+			if err := syncManager.Run(ctx); err != nil {
+				log.Errorf("event syncer stopped: %v", err)
+			}
+		}()
 	}
 
 	var rebooter *balenarerebooter.Rebooter
@@ -105,17 +159,145 @@ func main() {
 			config.RebooterRebootInterval,
 			config.RebootStateFile,
 		)
-		err := rebooter.Start(context.Background())
+		rebooter.BeforeRestart = func() {
+			recordStop(journal, bootjournal.Reboot, "")
+		}
+		err := rebooter.Start(ctx)
 		if err != nil {
 			log.Panicf("Error initializing rebooter: %v", err)
 		}
 		defer rebooter.Stop()
 	}
 
-	mainLoop(upsManager, event, eventsRecorder, publisher, config)
+	var lastAlive *store.LastAlive
+	if config.LastAliveFile != "" {
+		lastAlive = store.NewLastAlive(config.LastAliveFile)
+	}
+
+	powerMonitor, err := monitor.New(monitor.Config{
+		MonitorID: config.MonitorID,
+		Tags: []string{
+			"state:" + config.State,
+			"city:" + config.City,
+			"municipality:" + config.Municipality,
+			"parish:" + config.Parish,
+			"monitor-id:" + config.MonitorID,
+		},
+		TickInterval:  config.TickerDuration,
+		ProbeInterval: config.ProbeInterval,
+		LogInterval:   1 * time.Hour,
+		Detector: outagedetector.Config{
+			EnterThreshold_mA:   config.OutageEnterThreshold,
+			ExitThreshold_mA:    config.OutageExitThreshold,
+			Samples:             config.OutageSamples,
+			MinDwell:            config.OutageMinDwell,
+			ConfirmBusVoltage_V: config.OutageConfirmBusVoltage,
+		},
+		BatteryCapacity_mAh: config.BatteryCapacity,
+		LastAliveInterval:   config.LastAliveInterval,
+		BootReason:          string(boot.Reason),
+		Crashing:            safeMode.Enabled(),
+		MaxBackoff:          config.SensorMaxBackoff,
+		ReinitializeAfter:   config.SensorReinitializeAfter,
+	}, monitor.Dependencies{
+		Sensor:    sensor,
+		Estimator: estimator,
+		Recorder:  eventsRecorder,
+		Publisher: publisher,
+		Metrics:   util.GetProvider(),
+		LastAlive: lastAlive,
+		Telemetry: telemetry.NewCollector(version, config.EventsFolder, time.Now(), func() (int, error) {
+			names, _, err := eventsRecorder.GetFinishedEvents()
+			pending := len(names)
+			for _, queue := range queues {
+				pending += queue.Len()
+			}
+			return pending, err
+		}),
+	})
+	if err != nil {
+		log.Fatalf("unable to initialize the monitor: %v", err)
+	}
+
+	if err := powerMonitor.Run(ctx); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	// A second signal kills the program right away.
+	stop()
+	log.Infof("Shutting down, waiting up to %v", config.ShutdownGracePeriod)
+	deadline := time.After(config.ShutdownGracePeriod)
+	if err := powerMonitor.Persist(); err != nil {
+		log.Errorf("could not persist the ongoing incident: %v", err)
+	}
+	select {
+	case <-syncDone:
+	case <-deadline:
+		log.Warnf("event sync didn't finish within %v", config.ShutdownGracePeriod)
+	}
+	recordStop(journal, bootjournal.Signal, "")
+	// The deferred calls stop the rebooter and close the publisher and the
+	// UPS bus.
 	log.Infof("Program is exiting")
 }
 
+// startBootJournal records the start of the monitor and returns why the
+// previous run stopped. The stop of this run is recorded as a crash if the
+// program exits through log.Fatalf. The journal is nil when it is disabled
+// or can't be read.
+func startBootJournal(config Config, version string, recorder store.OutageRecorder) (*bootjournal.Journal, bootjournal.BootEvent) {
+	if config.BootJournalFile == "" {
+		return nil, bootjournal.BootEvent{}
+	}
+	journal, err := bootjournal.Open(config.BootJournalFile, version)
+	if err != nil {
+		log.Errorf("boot journal is disabled: %v", err)
+		return nil, bootjournal.BootEvent{}
+	}
+
+	_, err = recorder.GetMostRecentEvent()
+	boot, err := journal.Start(time.Now(), err == nil)
+	if err != nil {
+		log.Errorf("could not record the start in the boot journal: %v", err)
+	}
+	log.Infof("The previous run stopped because of: %v", boot.Reason)
+
+	log.RegisterExitHandler(func() {
+		recordStop(journal, bootjournal.Crash, "fatal error")
+	})
+	return journal, boot
+}
+
+// recordStop records the stop of this run, unless the reason was already
+// recorded, e.g. by the code that failed.
+func recordStop(journal *bootjournal.Journal, reason bootjournal.Reason, detail string) {
+	if journal == nil || (reason == bootjournal.Crash && journal.Stopped()) {
+		return
+	}
+	if err := journal.Stop(time.Now(), reason, detail); err != nil {
+		log.Errorf("could not record the stop in the boot journal: %v", err)
+	}
+}
+
+// executableVersion identifies the build that is running by the hash of the
+// executable, so a Balena update shows up as a new version.
+func executableVersion() string {
+	path, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:12]
+}
+
 type DeviceEvent struct {
 	DeviceID  string    `json:"device-id"`
 	Latitude  float64   `json:"lat"`
@@ -124,207 +306,380 @@ type DeviceEvent struct {
 	EventTime time.Time `json:"event_time"`
 }
 
-func mainLoop(upsManager *ups.UPSManager,
-	event *store.OutageEvent,
-	eventsRecorder store.OutageRecorder,
-	publisher store.Publisher,
-	config Config) {
-
-	ticker := time.NewTicker(config.TickerDuration)
-	defer ticker.Stop()
-	statsd := util.GetProvider()
-	baseTags := []string{
-		"state:" + config.State,
-		"city:" + config.City,
-		"municipality:" + config.Municipality,
-		"parish:" + config.Parish,
-		"monitor-id:" + config.MonitorID,
-	}
-
-	// Make sure that we log info the first time, after that only one log entry per hour.
-	// This is to not spam the logs.
-
-	events, err := fetchLastDayProbes(config.MonitorID)
-	if err == nil {
-		eventCount := eventsreader.CountEventsInDuration(events, 1*time.Hour)
-		log.Infof("Found %v events for monitor: %v", eventCount, config.MonitorID)
-		if eventCount >= 3 {
-			if events[0].Status != "crashing" {
-				publishProbe(publisher, config.MonitorID, "crashing")
-			}
-			log.Panicf("This device seems to be in a crash loop. There have been %v restarts in the last hour", eventCount)
-		}
+// checkCrashLoop turns on safe mode when the boot journal shows that the
+// monitor kept crashing within CRASH_LOOP_WINDOW.
+func checkCrashLoop(journal *bootjournal.Journal, config Config) bootjournal.SafeMode {
+	if journal == nil {
+		log.Warnf("Crash loop detection is disabled without the boot journal")
+		return bootjournal.SafeMode{}
+	}
+	detector := bootjournal.CrashLoop{
+		Threshold: config.CrashLoopThreshold,
+		Window:    config.CrashLoopWindow,
+	}
+	mode := detector.Check(journal.Entries(), time.Now())
+	log.Infof("Found %v restarts after a crash in the last %v", mode.Crashes, config.CrashLoopWindow)
+	if !mode.Enabled() {
+		return mode
+	}
+	if mode.Crashes == 0 {
+		log.Warnf("Staying in safe mode with %v disabled, the monitor didn't crash since it was entered", mode.Disabled)
+	} else {
+		log.Errorf(
+			"This device seems to be in a crash loop. There have been %v restarts in the last %v. Entering safe mode with %v disabled",
+			mode.Crashes,
+			config.CrashLoopWindow,
+			mode.Disabled,
+		)
+	}
+	if err := journal.EnterSafeMode(time.Now(), mode.Disabled); err != nil {
+		log.Errorf("could not record safe mode in the boot journal: %v", err)
 	}
+	return mode
+}
 
+func newBatteryEstimator(config Config) (*battery.Estimator, error) {
+	chemistry, err := battery.ParseChemistry(config.BatteryChemistry)
 	if err != nil {
-		log.Warnf("Warning, couldn't fetch events to get probe status. Failed to read from db: %v", err)
+		return nil, err
 	}
+	cells := config.BatteryCells
+	if cells == 0 {
+		cells = 1
+	}
+	return battery.NewEstimator(chemistry, cells, config.BatteryCellResistance)
+}
 
-	lastProbeTime, lastLog := publishInitialProbe(publisher, config)
+type Config struct {
+	State                   string        `mapstructure:"STATE"`
+	City                    string        `mapstructure:"CITY"`
+	Municipality            string        `mapstructure:"MUNICIPALITY"`
+	Parish                  string        `mapstructure:"PARISH"`
+	MonitorID               string        `mapstructure:"ID"`
+	TickerDuration          time.Duration `mapstructure:"TICKER"`
+	Lat                     float64       `mapstructure:"LAT"`
+	Long                    float64       `mapstructure:"LONG"`
+	EventsFolder            string        `mapstructure:"EVENTS_FOLDER"`
+	FinishedEventsFolder    string        `mapstructure:"FINISHED_EVENTS_FOLDER"`
+	RebootStateFile         string        `mapstructure:"REBOOT_STATE_FILE"`
+	RebooterEnabled         bool          `mapstructure:"REBOOTER_ENABLED"`
+	RebooterCheckInterval   time.Duration `mapstructure:"REBOOTER_CHECK_INTERVAL"`
+	RebooterRebootInterval  time.Duration `mapstructure:"REBOOTER_REBOOT_INTERVAL"`
+	SensorDriver            string        `mapstructure:"SENSOR_DRIVER"`
+	SimulatedScript         string        `mapstructure:"SIMULATED_SCRIPT"`
+	I2CBus                  int           `mapstructure:"I2C_BUS"`
+	I2CAddress              string        `mapstructure:"I2C_ADDRESS"`
+	Calibration             string        `mapstructure:"INA219_CALIBRATION"`
+	ShuntOhms               float64       `mapstructure:"INA219_SHUNT_OHMS"`
+	MaxExpectedCurrent      float64       `mapstructure:"INA219_MAX_CURRENT_A"`
+	BusVoltageRange         int           `mapstructure:"INA219_BUS_VOLTAGE_RANGE"`
+	ShuntGain               int           `mapstructure:"INA219_SHUNT_RANGE_MV"`
+	ADCSamples              int           `mapstructure:"INA219_ADC_SAMPLES"`
+	BatteryChemistry        string        `mapstructure:"BATTERY_CHEMISTRY"`
+	BatteryCells            int           `mapstructure:"BATTERY_CELLS"`
+	BatteryCellResistance   float64       `mapstructure:"BATTERY_CELL_RESISTANCE_OHMS"`
+	BatteryCapacity         float64       `mapstructure:"BATTERY_CAPACITY_MAH"`
+	OutageEnterThreshold    float32       `mapstructure:"OUTAGE_ENTER_THRESHOLD_MA"`
+	OutageExitThreshold     float32       `mapstructure:"OUTAGE_EXIT_THRESHOLD_MA"`
+	OutageSamples           int           `mapstructure:"OUTAGE_SAMPLES"`
+	OutageMinDwell          time.Duration `mapstructure:"OUTAGE_MIN_DWELL"`
+	OutageConfirmBusVoltage float32       `mapstructure:"OUTAGE_CONFIRM_BUS_VOLTAGE"`
+	ShutdownGracePeriod     time.Duration `mapstructure:"SHUTDOWN_GRACE_PERIOD"`
+	LastAliveFile           string        `mapstructure:"LAST_ALIVE_FILE"`
+	LastAliveInterval       time.Duration `mapstructure:"LAST_ALIVE_INTERVAL"`
+	BootJournalFile         string        `mapstructure:"BOOT_JOURNAL_FILE"`
+	CrashLoopThreshold      int           `mapstructure:"CRASH_LOOP_THRESHOLD"`
+	CrashLoopWindow         time.Duration `mapstructure:"CRASH_LOOP_WINDOW"`
+	ProbeInterval           time.Duration `mapstructure:"PROBE_INTERVAL"`
+	SensorMaxBackoff        time.Duration `mapstructure:"SENSOR_MAX_BACKOFF"`
+	SensorReinitializeAfter int           `mapstructure:"SENSOR_REINITIALIZE_AFTER"`
+	MySQLVerifySchema       bool          `mapstructure:"MYSQL_VERIFY_SCHEMA"`
+	PublisherSinks          string        `mapstructure:"PUBLISHER_SINKS"`
+	AngosturaEndpoint       string        `mapstructure:"ANGOSTURA_ENDPOINT"`
+	AngosturaTimeout        time.Duration `mapstructure:"ANGOSTURA_TIMEOUT"`
+	AngosturaBearerToken    string        `mapstructure:"ANGOSTURA_BEARER_TOKEN"`
+	AngosturaAPIKey         string        `mapstructure:"ANGOSTURA_API_KEY"`
+	AngosturaAPIKeyHeader   string        `mapstructure:"ANGOSTURA_API_KEY_HEADER"`
+	MQTTBroker              string        `mapstructure:"MQTT_BROKER"`
+	MQTTTopicPrefix         string        `mapstructure:"MQTT_TOPIC_PREFIX"`
+	MQTTUsername            string        `mapstructure:"MQTT_USERNAME"`
+	MQTTPassword            string        `mapstructure:"MQTT_PASSWORD"`
+	MQTTKeepAlive           time.Duration `mapstructure:"MQTT_KEEP_ALIVE"`
+	WebhookURLs             string        `mapstructure:"WEBHOOK_URLS"`
+	WebhookSecrets          string        `mapstructure:"WEBHOOK_SECRETS"`
+	WebhookTimeout          time.Duration `mapstructure:"WEBHOOK_TIMEOUT"`
+	PusherAppID             string        `mapstructure:"PUSHER_APP_ID"`
+	PusherKey               string        `mapstructure:"PUSHER_KEY"`
+	PusherSecret            string        `mapstructure:"PUSHER_SECRET"`
+	PusherCluster           string        `mapstructure:"PUSHER_CLUSTER"`
+	PusherChannelPrefix     string        `mapstructure:"PUSHER_CHANNEL_PREFIX"`
+	PusherTimeout           time.Duration `mapstructure:"PUSHER_TIMEOUT"`
+	OutboxFolder            string        `mapstructure:"OUTBOX_FOLDER"`
+	OutboxMaxAge            time.Duration `mapstructure:"OUTBOX_MAX_AGE"`
+	OutboxMaxMessages       int           `mapstructure:"OUTBOX_MAX_MESSAGES"`
+	OutboxMaxBytes          int64         `mapstructure:"OUTBOX_MAX_BYTES"`
+	OutboxMaxAttempts       int           `mapstructure:"OUTBOX_MAX_ATTEMPTS"`
+}
 
-	for {
-		// Let's initialize a probe timer to send keep alives to angostura
-		select {
-		case <-ticker.C:
+// redacted returns a copy of the config that is safe to log, with the
+// credentials masked.
+func (c Config) redacted() Config {
+	for _, secret := range []*string{
+		&c.AngosturaBearerToken,
+		&c.AngosturaAPIKey,
+		&c.MQTTPassword,
+		&c.WebhookSecrets,
+		&c.PusherSecret,
+	} {
+		if *secret != "" {
+			*secret = "REDACTED"
+		}
+	}
+	return c
+}
 
-			percentage := powerPercentage(upsManager)
-			current, err := upsManager.GetCurrent_mA()
+// newSinks creates the destinations named in PUBLISHER_SINKS. They connect on
+// the first message, so the monitor starts while the device is offline.
+func newSinks(config Config) ([]store.Sink, error) {
+	var sinks []store.Sink
+	for _, name := range strings.Split(config.PublisherSinks, ",") {
+		name = strings.TrimSpace(name)
+		var publisher store.Publisher
+		switch name {
+		case "":
+			continue
+		case "mysql":
+			dsn := os.Getenv("MYSQL_DSN")
+			if dsn == "" {
+				return nil, fmt.Errorf("MYSQL_DSN environment variable is not set")
+			}
+			mysqlPublisher, err := store.NewLazyMySQLPublisher(dsn, config.MySQLVerifySchema)
 			if err != nil {
-				log.Fatalf("unexpected error reading current: %v", err)
+				return nil, fmt.Errorf("error creating MySQLPublisher: %v", err)
 			}
-
-			statsd.Gauge(
-				"powermonitor.batterylevel",
-				float64(percentage),
-				baseTags,
-				1,
-			)
-			if current < -10 {
-
-				if time.Since(lastLog) >= 1*time.Hour {
-					log.Infof(
-						"Power is not available. This is the remaining battery: %.1f%%, current: %.1f",
-						percentage,
-						current,
-					)
-					lastLog = time.Now()
-				}
-				statsd.Gauge(
-					"powermonitor.outage",
-					0,
-					baseTags,
-					1,
-				)
-				if event == nil {
-					log.Infof("There is no ongoing incident. Starting a new one.")
-					newEvent, err := eventsRecorder.StartIncident()
-					if err != nil {
-						log.Fatalf("error starting new event: %v", err)
-					}
-					event = newEvent
-				}
-				continue
+			publisher = mysqlPublisher
+		case "angostura":
+			if config.AngosturaEndpoint == "" {
+				return nil, fmt.Errorf("ANGOSTURA_ENDPOINT is not set")
 			}
-			if time.Since(lastLog) >= 1*time.Hour {
-				log.Infof("Power is available. This is the remaining battery: %.1f%%", percentage)
-				lastLog = time.Now()
+			publisher = store.NewAngosturaUploader(store.AngosturaConfig{
+				Endpoint:     config.AngosturaEndpoint,
+				Timeout:      config.AngosturaTimeout,
+				BearerToken:  config.AngosturaBearerToken,
+				APIKey:       config.AngosturaAPIKey,
+				APIKeyHeader: config.AngosturaAPIKeyHeader,
+			})
+		case "mqtt":
+			if config.MQTTBroker == "" {
+				return nil, fmt.Errorf("MQTT_BROKER is not set")
 			}
-
-			if time.Since(lastProbeTime) >= 4*time.Hour {
-				newProbeTime := publishProbe(publisher, config.MonitorID, "healthy")
-				if !newProbeTime.IsZero() {
-					lastProbeTime = newProbeTime
-				}
+			mqttPublisher, err := store.NewMQTTPublisher(store.MQTTConfig{
+				Broker:      config.MQTTBroker,
+				MonitorID:   config.MonitorID,
+				Username:    config.MQTTUsername,
+				Password:    config.MQTTPassword,
+				TopicPrefix: config.MQTTTopicPrefix,
+				KeepAlive:   config.MQTTKeepAlive,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("error creating MQTTPublisher: %v", err)
+			}
+			publisher = mqttPublisher
+		case "webhook":
+			// A sink per URL, so each gets its own outbox.
+			webhooks, err := newWebhookPublishers(config)
+			if err != nil {
+				return nil, err
 			}
-			statsd.Gauge(
-				"powermonitor.outage",
-				1,
-				baseTags,
-				1,
-			)
-			if event != nil {
-				log.Infof("Power outage ended. Recording event")
-				err := eventsRecorder.FinishIncident()
-				if err != nil {
-					log.Fatalf("unexpected error finishing incident: %v", err)
-				}
-				event = nil
+			for _, webhook := range webhooks {
+				sinks = append(sinks, store.Sink{Name: webhook.Name(), Publisher: webhook})
 			}
+			continue
+		case "pusher":
+			pusherPublisher, err := store.NewPusherPublisher(store.PusherConfig{
+				AppID:         config.PusherAppID,
+				Key:           config.PusherKey,
+				Secret:        config.PusherSecret,
+				Cluster:       config.PusherCluster,
+				Timeout:       config.PusherTimeout,
+				ChannelPrefix: config.PusherChannelPrefix,
+				State:         config.State,
+				Municipality:  config.Municipality,
+				Parish:        config.Parish,
+				MonitorID:     config.MonitorID,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("error creating PusherPublisher: %v", err)
+			}
+			publisher = pusherPublisher
+		default:
+			return nil, fmt.Errorf("unknown publisher sink %q, expected mysql, angostura, mqtt, webhook or pusher", name)
 		}
+		sinks = append(sinks, store.Sink{Name: name, Publisher: publisher})
 	}
+	if len(sinks) == 0 {
+		return nil, fmt.Errorf("PUBLISHER_SINKS is empty")
+	}
+	return sinks, nil
 }
 
-func fetchLastDayProbes(deviceId string) ([]eventsreader.Event, error) {
-	dsn := os.Getenv("MYSQL_DSN")
-	if dsn == "" {
-		return nil, fmt.Errorf("MYSQL_DSN is not set")
+// newWebhookPublishers pairs the URLs in WEBHOOK_URLS with the secrets in
+// WEBHOOK_SECRETS, both comma separated and in the same order.
+func newWebhookPublishers(config Config) ([]*store.WebhookPublisher, error) {
+	urls := strings.Split(config.WebhookURLs, ",")
+	secrets := strings.Split(config.WebhookSecrets, ",")
+	if strings.TrimSpace(config.WebhookURLs) == "" {
+		return nil, fmt.Errorf("WEBHOOK_URLS is not set")
+	}
+	if len(urls) != len(secrets) {
+		return nil, fmt.Errorf("WEBHOOK_URLS has %v URLs but WEBHOOK_SECRETS has %v secrets", len(urls), len(secrets))
+	}
+	var publishers []*store.WebhookPublisher
+	for i := range urls {
+		publisher, err := store.NewWebhookPublisher(store.WebhookConfig{
+			URL:     strings.TrimSpace(urls[i]),
+			Secret:  strings.TrimSpace(secrets[i]),
+			Timeout: config.WebhookTimeout,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error creating WebhookPublisher: %v", err)
+		}
+		publishers = append(publishers, publisher)
 	}
+	return publishers, nil
+}
 
-	db, err := sql.Open("mysql", dsn)
+// newPublisher returns the publisher writing to every sink. Each sink gets
+// its own outbox, so a message is retried only for the sinks that didn't get
+// it. The outboxes are returned to report their backlog. When disabled, the
+// messages are kept in the outboxes until publishing is enabled again. A
+// sink that panics is recorded in journal as a crash of the publisher.
+func newPublisher(config Config, sinks []store.Sink, journal *bootjournal.Journal, disabled bool) (store.Publisher, []*outbox.Outbox) {
+	var queues []*outbox.Outbox
+	wrapped := make([]store.Sink, len(sinks))
+	for i, sink := range sinks {
+		wrapped[i] = sink
+		wrapped[i].Publisher = crashRecordingPublisher{publisher: sink.Publisher, journal: journal}
+		if disabled {
+			wrapped[i].Publisher = store.DisabledPublisher{}
+		}
+		if config.OutboxFolder == "" {
+			continue
+		}
+		queue, err := outbox.Open(filepath.Join(config.OutboxFolder, sink.Name), wrapped[i].Publisher, outbox.Config{
+			MaxAge:      config.OutboxMaxAge,
+			MaxMessages: config.OutboxMaxMessages,
+			MaxBytes:    config.OutboxMaxBytes,
+			MaxAttempts: config.OutboxMaxAttempts,
+		})
+		if err != nil {
+			log.Errorf("unable to open the outbox of %v, publishing without it: %v", sink.Name, err)
+			continue
+		}
+		queues = append(queues, queue)
+		wrapped[i].Publisher = queue
+	}
+	publisher, err := store.NewMultiPublisher(wrapped...)
 	if err != nil {
-		return nil, err
+		log.Fatalf("unable to initialize the publisher: %v", err)
 	}
+	return publisher, queues
+}
 
-	defer db.Close()
-
-	if err := db.Ping(); err != nil {
-		return nil, err
+// migrate brings the database at dsn to the schema of this build.
+func migrate(dsn string) error {
+	if dsn == "" {
+		return fmt.Errorf("MYSQL_DSN environment variable is not set")
 	}
-	events, err := eventsreader.GetEventsForDevice(db, deviceId)
+	db, err := sql.Open("mysql", dsn)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if len(events) == 0 {
-		return []eventsreader.Event{}, nil
+	defer db.Close()
+	version, err := store.Migrate(db)
+	if err != nil {
+		return err
 	}
-
-	return events, nil
+	log.Infof("The database is at schema version %v", version)
+	return nil
 }
 
-func publishInitialProbe(publisher store.Publisher, config Config) (time.Time, time.Time) {
-	probeTime := publishProbe(publisher, config.MonitorID, "restarting")
-	lastProbeTime := time.Now()
-
-	lastLog := time.Now().Add(-2 * time.Hour)
-	if probeTime.IsZero() {
-		log.Errorf("failed to published to angostura on start")
-	} else {
-		lastProbeTime = probeTime
+// newPowerSensor returns the sensor selected by SENSOR_DRIVER. The INA219 on
+// the UPS HAT is the default, "simulated" plays back SIMULATED_SCRIPT so the
+// monitor can run on a laptop or in CI.
+func newPowerSensor(config Config) (ups.PowerSensor, error) {
+	switch config.SensorDriver {
+	case "", "ina219":
+		cal, err := calibrationFromConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		addr, err := ups.ParseAddress(config.I2CAddress)
+		if err != nil {
+			return nil, err
+		}
+		bus := config.I2CBus
+		if bus == 0 {
+			bus = ups.DefaultBus
+		}
+		manager, err := ups.NewManager(ups.ManagerConfig{
+			Bus:         bus,
+			Address:     addr,
+			Calibration: cal,
+		})
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("Using INA219 at 0x%02x on I2C bus %v (%v)", manager.Address(), bus, manager.Board())
+		return manager, nil
+	case "simulated":
+		script := config.SimulatedScript
+		if script == "" {
+			script = defaultSimulatedScript
+		}
+		samples, err := ups.ParseScript(script)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("Using simulated power sensor with script: %v", script)
+		return ups.NewSimulatedSensor(true, samples...), nil
+	default:
+		return nil, fmt.Errorf("unknown sensor driver: %v", config.SensorDriver)
 	}
-	return lastProbeTime, lastLog
 }
 
-func publishProbe(publisher store.Publisher, deviceId, status string) time.Time {
-	event := eventsreader.Event{
-		DeviceID: deviceId,
-		SentAt:   time.Now(),
-		Status:   status,
+// calibrationFromConfig returns the INA219_CALIBRATION preset, or when it is
+// "custom", the calibration described by the other INA219_* settings.
+func calibrationFromConfig(config Config) (ups.Calibration, error) {
+	if config.Calibration != "custom" {
+		return ups.CalibrationPreset(config.Calibration)
 	}
-	// Serialize the struct to JSON
-	jsonData, err := json.Marshal(event)
+	busVoltageRange, err := ups.BusVoltageRangeFromVolts(config.BusVoltageRange)
 	if err != nil {
-		panic("Failed to serialized event json")
+		return ups.Calibration{}, err
 	}
-	err = publisher.Publish("power_outage_probe", jsonData)
+	gain, err := ups.GainFromMillivolts(config.ShuntGain)
 	if err != nil {
-		log.Errorf("failed to publish probe event to angostura: %v", err)
-		return time.Time{}
+		return ups.Calibration{}, err
 	}
-	log.Infof("successfully published probe event to angostura")
-	return time.Now()
-}
-
-func powerPercentage(upsManager *ups.UPSManager) float32 {
-	busVoltage, err := upsManager.GetBusVoltage_V()
+	adcResolution, err := ups.ADCResolutionFromSamples(config.ADCSamples)
 	if err != nil {
-		panic(err)
-	}
-	p := (busVoltage - 3) / 1.2 * 100
-	if p > 100 {
-		p = 100
-	}
-	if p < 0 {
-		p = 0
+		return ups.Calibration{}, err
 	}
-	return p
+	return ups.Calibration{
+		ShuntOhms:            config.ShuntOhms,
+		MaxExpectedCurrent_A: config.MaxExpectedCurrent,
+		BusVoltageRange:      busVoltageRange,
+		Gain:                 gain,
+		BusADCResolution:     adcResolution,
+		ShuntADCResolution:   adcResolution,
+		Mode:                 ups.SANDBVOLT_CONTINUOUS,
+	}, nil
 }
 
-type Config struct {
-	State                  string        `mapstructure:"STATE"`
-	City                   string        `mapstructure:"CITY"`
-	Municipality           string        `mapstructure:"MUNICIPALITY"`
-	Parish                 string        `mapstructure:"PARISH"`
-	MonitorID              string        `mapstructure:"ID"`
-	TickerDuration         time.Duration `mapstructure:"TICKER"`
-	Lat                    float64       `mapstructure:"LAT"`
-	Long                   float64       `mapstructure:"LONG"`
-	EventsFolder           string        `mapstructure:"EVENTS_FOLDER"`
-	FinishedEventsFolder   string        `mapstructure:"FINISHED_EVENTS_FOLDER"`
-	RebootStateFile        string        `mapstructure:"REBOOT_STATE_FILE"`
-	RebooterEnabled        bool          `mapstructure:"REBOOTER_ENABLED"`
-	RebooterCheckInterval  time.Duration `mapstructure:"REBOOTER_CHECK_INTERVAL"`
-	RebooterRebootInterval time.Duration `mapstructure:"REBOOTER_REBOOT_INTERVAL"`
-}
+// defaultSimulatedScript keeps the power on for a while and then simulates a
+// short outage.
+const defaultSimulatedScript = "4.1:500:5m,3.9:-800:2m"
 
 func loadConfig() Config {
 	viper.SetConfigName("config")
@@ -334,6 +689,28 @@ func loadConfig() Config {
 	viper.SetEnvPrefix("monitor")
 	viper.AutomaticEnv()
 
+	// Without these the detector behaves like it did before it was
+	// configurable: a single sample below -10mA is an outage.
+	viper.SetDefault("OUTAGE_ENTER_THRESHOLD_MA", -10)
+	viper.SetDefault("OUTAGE_EXIT_THRESHOLD_MA", -10)
+	viper.SetDefault("OUTAGE_SAMPLES", 1)
+	viper.SetDefault("SHUTDOWN_GRACE_PERIOD", "8s")
+	viper.SetDefault("LAST_ALIVE_INTERVAL", "1m")
+	viper.SetDefault("CRASH_LOOP_THRESHOLD", 3)
+	viper.SetDefault("CRASH_LOOP_WINDOW", "1h")
+	viper.SetDefault("PROBE_INTERVAL", "4h")
+	viper.SetDefault("PUBLISHER_SINKS", "mysql")
+	viper.SetDefault("ANGOSTURA_TIMEOUT", "10s")
+	viper.SetDefault("MQTT_TOPIC_PREFIX", "poweroutage")
+	viper.SetDefault("MQTT_KEEP_ALIVE", "1m")
+	viper.SetDefault("WEBHOOK_TIMEOUT", "10s")
+	viper.SetDefault("PUSHER_CHANNEL_PREFIX", "poweroutage")
+	viper.SetDefault("PUSHER_TIMEOUT", "10s")
+	viper.SetDefault("OUTBOX_MAX_AGE", "168h")
+	viper.SetDefault("OUTBOX_MAX_MESSAGES", 10000)
+	viper.SetDefault("OUTBOX_MAX_BYTES", 50<<20)
+	viper.SetDefault("OUTBOX_MAX_ATTEMPTS", 100)
+
 	if err := viper.ReadInConfig(); err != nil {
 		log.Fatalf("failed to read config file: %v", err)
 	}
@@ -18,7 +18,10 @@ type Rebooter struct {
 	CheckInterval  time.Duration
 	RebootInterval time.Duration
 	FilePath       string
-	cancelFunc     context.CancelFunc // Store the cancel function to allow stopping
+	// BeforeRestart, when set, is called right before asking the supervisor
+	// to reboot the device.
+	BeforeRestart func()
+	cancelFunc    context.CancelFunc // Store the cancel function to allow stopping
 }
 
 // New creates a new Restarter instance.
@@ -69,6 +72,9 @@ func (r *Rebooter) Start(ctx context.Context) error {
 						log.Errorf("There was an error rebooting device. Will retry again in: %v", r.CheckInterval)
 						continue
 					}
+					if r.BeforeRestart != nil {
+						r.BeforeRestart()
+					}
 					err = r.restartApp()
 					if err != nil {
 						// This is effectively rolling back the last restart
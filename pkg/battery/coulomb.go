@@ -0,0 +1,74 @@
+package battery
+
+import (
+	"time"
+)
+
+// CoulombCounter estimates the charge left in the battery during an outage by
+// integrating the current drawn from it, starting from the state of charge
+// estimated from the voltage when the outage began.
+type CoulombCounter struct {
+	capacity_mAh float64
+	start_mAh    float64
+	drawn_mAh    float64
+	startTime    time.Time
+	lastTime     time.Time
+	lastCurrent  float64
+}
+
+// NewCoulombCounter returns a counter for a battery of capacity_mAh.
+func NewCoulombCounter(capacity_mAh float64) *CoulombCounter {
+	return &CoulombCounter{capacity_mAh: capacity_mAh}
+}
+
+// Reset starts counting from stateOfCharge percent at the given time.
+func (c *CoulombCounter) Reset(at time.Time, stateOfCharge float32) {
+	c.start_mAh = c.capacity_mAh * float64(stateOfCharge) / 100
+	c.drawn_mAh = 0
+	c.startTime = at
+	c.lastTime = time.Time{}
+	c.lastCurrent = 0
+}
+
+// Add integrates a current sample taken at the given time. Samples must be
+// added in order. The current is negative while discharging.
+func (c *CoulombCounter) Add(at time.Time, current_mA float32) {
+	current := float64(current_mA)
+	if !c.lastTime.IsZero() && at.After(c.lastTime) {
+		hours := at.Sub(c.lastTime).Hours()
+		// Trapezoidal rule between the previous and this sample.
+		c.drawn_mAh -= (c.lastCurrent + current) / 2 * hours
+	}
+	c.lastTime = at
+	c.lastCurrent = current
+}
+
+// Remaining_mAh returns the charge left, never below zero.
+func (c *CoulombCounter) Remaining_mAh() float64 {
+	remaining := c.start_mAh - c.drawn_mAh
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// averageDischarge_mA returns the mean current drawn since Reset, or the last
+// sample when there isn't enough history yet.
+func (c *CoulombCounter) averageDischarge_mA() float64 {
+	elapsed := c.lastTime.Sub(c.startTime).Hours()
+	if c.lastTime.IsZero() || elapsed <= 0 || c.drawn_mAh <= 0 {
+		return -c.lastCurrent
+	}
+	return c.drawn_mAh / elapsed
+}
+
+// TimeToEmpty estimates how long the battery lasts at the average discharge
+// current. It returns false when the battery isn't discharging.
+func (c *CoulombCounter) TimeToEmpty() (time.Duration, bool) {
+	discharge := c.averageDischarge_mA()
+	if discharge <= 0 {
+		return 0, false
+	}
+	hours := c.Remaining_mAh() / discharge
+	return time.Duration(hours * float64(time.Hour)), true
+}
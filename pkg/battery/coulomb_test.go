@@ -0,0 +1,59 @@
+package battery
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoulombCounter(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	counter := NewCoulombCounter(2000)
+	counter.Reset(start, 50)
+	assert.InDelta(t, 1000, counter.Remaining_mAh(), 1e-9)
+
+	_, ok := counter.TimeToEmpty()
+	assert.False(t, ok, "no samples yet")
+
+	// A first sample gives an estimate from the instantaneous current.
+	counter.Add(start, -500)
+	tte, ok := counter.TimeToEmpty()
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Hour, tte)
+
+	// Half an hour at 500mA and half an hour ramping up to 700mA.
+	counter.Add(start.Add(30*time.Minute), -500)
+	counter.Add(start.Add(time.Hour), -700)
+	assert.InDelta(t, 1000-250-300, counter.Remaining_mAh(), 1e-6)
+
+	tte, ok = counter.TimeToEmpty()
+	assert.True(t, ok)
+	// 450mAh left at an average of 550mA.
+	assert.InDelta(t, (450.0 / 550 * float64(time.Hour)), float64(tte), float64(time.Second))
+}
+
+func TestCoulombCounterCharging(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	counter := NewCoulombCounter(1000)
+	counter.Reset(start, 10)
+	counter.Add(start, 300)
+	counter.Add(start.Add(time.Hour), 300)
+
+	assert.InDelta(t, 400, counter.Remaining_mAh(), 1e-6)
+	_, ok := counter.TimeToEmpty()
+	assert.False(t, ok)
+}
+
+func TestCoulombCounterEmpty(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	counter := NewCoulombCounter(1000)
+	counter.Reset(start, 10)
+	counter.Add(start, -1000)
+	counter.Add(start.Add(time.Hour), -1000)
+
+	assert.Equal(t, float64(0), counter.Remaining_mAh())
+	tte, ok := counter.TimeToEmpty()
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), tte)
+}
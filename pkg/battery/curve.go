@@ -0,0 +1,131 @@
+package battery
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Point is the open circuit voltage of a cell at a state of charge.
+type Point struct {
+	Voltage float64
+	Percent float64
+}
+
+// Curve is a discharge curve of a single cell, sorted by voltage.
+type Curve []Point
+
+// Percent interpolates linearly between the two points around voltage.
+// Voltages outside of the curve are clamped to 0% and 100%.
+func (c Curve) Percent(voltage float64) float64 {
+	if len(c) == 0 {
+		return 0
+	}
+	if voltage <= c[0].Voltage {
+		return c[0].Percent
+	}
+	for i := 1; i < len(c); i++ {
+		if voltage <= c[i].Voltage {
+			low, high := c[i-1], c[i]
+			ratio := (voltage - low.Voltage) / (high.Voltage - low.Voltage)
+			return low.Percent + ratio*(high.Percent-low.Percent)
+		}
+	}
+	return c[len(c)-1].Percent
+}
+
+// Chemistry is the cell chemistry of the battery pack.
+type Chemistry int
+
+const (
+	LiIon Chemistry = iota
+	LiPo
+	LiFePO4
+)
+
+var chemistryStrings = [...]string{
+	"li-ion",
+	"lipo",
+	"lifepo4",
+}
+
+func (c Chemistry) String() string {
+	if int(c) < 0 || int(c) >= len(chemistryStrings) {
+		return "unknown"
+	}
+	return chemistryStrings[c]
+}
+
+// ParseChemistry parses "li-ion", "lipo" or "lifepo4". The empty string is
+// Li-ion, the 18650 cells the UPS HATs take.
+func ParseChemistry(s string) (Chemistry, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return LiIon, nil
+	}
+	for i, str := range chemistryStrings {
+		if s == str {
+			return Chemistry(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid battery chemistry: %s", s)
+}
+
+// Curve returns the resting discharge curve of a cell of this chemistry.
+func (c Chemistry) Curve() Curve {
+	switch c {
+	case LiPo:
+		return lipoCurve
+	case LiFePO4:
+		return lifepo4Curve
+	}
+	return liIonCurve
+}
+
+// liIonCurve is the resting voltage of an 18650 NMC cell.
+var liIonCurve = Curve{
+	{3.00, 0},
+	{3.30, 5},
+	{3.45, 10},
+	{3.55, 20},
+	{3.62, 30},
+	{3.68, 40},
+	{3.74, 50},
+	{3.80, 60},
+	{3.87, 70},
+	{3.95, 80},
+	{4.05, 90},
+	{4.20, 100},
+}
+
+// lipoCurve is the resting voltage of a LiCoO2 pouch cell.
+var lipoCurve = Curve{
+	{3.27, 0},
+	{3.61, 5},
+	{3.69, 10},
+	{3.73, 20},
+	{3.77, 30},
+	{3.79, 40},
+	{3.82, 50},
+	{3.87, 60},
+	{3.92, 70},
+	{3.97, 80},
+	{4.06, 90},
+	{4.20, 100},
+}
+
+// lifepo4Curve is the resting voltage of a LiFePO4 cell. It is very flat, so
+// expect the estimate to be coarse between 20% and 80%.
+var lifepo4Curve = Curve{
+	{2.50, 0},
+	{3.00, 5},
+	{3.13, 10},
+	{3.20, 20},
+	{3.23, 30},
+	{3.26, 40},
+	{3.27, 50},
+	{3.28, 60},
+	{3.30, 70},
+	{3.32, 80},
+	{3.34, 90},
+	{3.40, 100},
+}
@@ -0,0 +1,46 @@
+package battery
+
+import "fmt"
+
+// Estimator estimates the state of charge of a battery pack from the voltage
+// and current measured by the UPS.
+type Estimator struct {
+	Chemistry Chemistry
+	// Cells is the number of cells in series, 2 on the UPS HAT (B).
+	Cells int
+	// CellResistanceOhms is the internal resistance of a cell. The voltage
+	// drop it causes under load is added back before looking up the curve.
+	CellResistanceOhms float64
+}
+
+// NewEstimator validates the pack description.
+func NewEstimator(chemistry Chemistry, cells int, cellResistanceOhms float64) (*Estimator, error) {
+	if cells < 1 {
+		return nil, fmt.Errorf("a battery needs at least one cell, got %v", cells)
+	}
+	if cellResistanceOhms < 0 {
+		return nil, fmt.Errorf("cell resistance can't be negative, got %v", cellResistanceOhms)
+	}
+	return &Estimator{
+		Chemistry:          chemistry,
+		Cells:              cells,
+		CellResistanceOhms: cellResistanceOhms,
+	}, nil
+}
+
+// OpenCircuitVoltage returns the voltage of a single cell once the drop
+// caused by the current is compensated. The current is positive when the
+// battery is charging and negative when it is discharging.
+func (e *Estimator) OpenCircuitVoltage(busVoltage_V, current_mA float32) float64 {
+	cells := e.Cells
+	if cells < 1 {
+		cells = 1
+	}
+	cellVoltage := float64(busVoltage_V) / float64(cells)
+	return cellVoltage - float64(current_mA)/1000*e.CellResistanceOhms
+}
+
+// StateOfCharge returns the charge left in the pack, between 0 and 100.
+func (e *Estimator) StateOfCharge(busVoltage_V, current_mA float32) float32 {
+	return float32(e.Chemistry.Curve().Percent(e.OpenCircuitVoltage(busVoltage_V, current_mA)))
+}
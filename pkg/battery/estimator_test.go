@@ -0,0 +1,90 @@
+package battery
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCurvePercent(t *testing.T) {
+	curve := Curve{{3.0, 0}, {3.5, 20}, {4.0, 100}}
+	tests := []struct {
+		voltage float64
+		want    float64
+	}{
+		{2.5, 0},
+		{3.0, 0},
+		{3.25, 10},
+		{3.5, 20},
+		{3.75, 60},
+		{4.0, 100},
+		{4.5, 100},
+	}
+	for _, tt := range tests {
+		assert.InDelta(t, tt.want, curve.Percent(tt.voltage), 1e-9, "%vV", tt.voltage)
+	}
+	assert.Equal(t, float64(0), Curve{}.Percent(3.7))
+}
+
+func TestCurvesAreSorted(t *testing.T) {
+	for _, chemistry := range []Chemistry{LiIon, LiPo, LiFePO4} {
+		curve := chemistry.Curve()
+		require.NotEmpty(t, curve, chemistry.String())
+		assert.Equal(t, float64(0), curve[0].Percent, chemistry.String())
+		assert.Equal(t, float64(100), curve[len(curve)-1].Percent, chemistry.String())
+		for i := 1; i < len(curve); i++ {
+			assert.Greater(t, curve[i].Voltage, curve[i-1].Voltage, chemistry.String())
+			assert.Greater(t, curve[i].Percent, curve[i-1].Percent, chemistry.String())
+		}
+	}
+}
+
+func TestParseChemistry(t *testing.T) {
+	for in, want := range map[string]Chemistry{
+		"":        LiIon,
+		"li-ion":  LiIon,
+		"LiPo":    LiPo,
+		"lifepo4": LiFePO4,
+	} {
+		got, err := ParseChemistry(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	_, err := ParseChemistry("nimh")
+	assert.Error(t, err)
+}
+
+func TestStateOfCharge(t *testing.T) {
+	single, err := NewEstimator(LiIon, 1, 0)
+	require.NoError(t, err)
+	tests := []struct {
+		name       string
+		estimator  *Estimator
+		busVoltage float32
+		current    float32
+		want       float32
+	}{
+		{"full", single, 4.2, 0, 100},
+		{"plateau", single, 3.77, 0, 55},
+		{"empty", single, 2.9, 0, 0},
+		// The same cell voltage on a 2S pack.
+		{"2S plateau", &Estimator{Chemistry: LiIon, Cells: 2}, 7.54, 0, 55},
+		// 3.71V under a 1A load with 60mΩ is 3.77V at rest.
+		{"load compensated", &Estimator{Chemistry: LiIon, Cells: 1, CellResistanceOhms: 0.06}, 3.71, -1000, 55},
+		// 3.83V while charging at 1A with 60mΩ is 3.77V at rest.
+		{"charge compensated", &Estimator{Chemistry: LiIon, Cells: 1, CellResistanceOhms: 0.06}, 3.83, 1000, 55},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.InDelta(t, tt.want, tt.estimator.StateOfCharge(tt.busVoltage, tt.current), 0.01)
+		})
+	}
+}
+
+func TestNewEstimatorValidates(t *testing.T) {
+	_, err := NewEstimator(LiIon, 0, 0.05)
+	assert.Error(t, err)
+	_, err = NewEstimator(LiIon, 1, -1)
+	assert.Error(t, err)
+}
@@ -0,0 +1,111 @@
+package bootjournal
+
+import "time"
+
+// Subsystem is a part of the monitor that safe mode can turn off.
+type Subsystem string
+
+const (
+	Publisher Subsystem = "publisher"
+	Sensor    Subsystem = "sensor"
+)
+
+// escalation is the order in which subsystems are turned off when the crashes
+// don't point to any of them.
+var escalation = []Subsystem{Publisher, Sensor}
+
+// CrashLoop detects when the monitor keeps crashing, from the starts recorded
+// in the journal.
+type CrashLoop struct {
+	// Threshold is how many restarts after a crash within Window make a crash
+	// loop.
+	Threshold int
+	Window    time.Duration
+}
+
+// SafeMode tells which subsystems to turn off.
+type SafeMode struct {
+	// Crashes is how many restarts after a crash there were within the
+	// window.
+	Crashes  int
+	Disabled []Subsystem
+}
+
+// Enabled reports whether any subsystem has to be turned off.
+func (s SafeMode) Enabled() bool {
+	return len(s.Disabled) > 0
+}
+
+// Disables reports whether subsystem has to be turned off.
+func (s SafeMode) Disables(subsystem Subsystem) bool {
+	for _, disabled := range s.Disabled {
+		if disabled == subsystem {
+			return true
+		}
+	}
+	return false
+}
+
+// Check decides what to turn off from the entries recorded within the window
+// before now. Once in safe mode, only the crashes since it was entered count:
+// the subsystems it turned off stay off, and more are turned off only if the
+// monitor crashed again. The subsystems named by the crashes are turned off.
+// If none are, or they were already turned off, the next subsystem in
+// escalation is turned off too.
+func (c CrashLoop) Check(entries []Entry, now time.Time) SafeMode {
+	var mode SafeMode
+	suspects := map[Subsystem]bool{}
+	var previous []Subsystem
+	inSafeMode := false
+	since := now.Add(-c.Window)
+	for _, entry := range entries {
+		if entry.Time.Before(since) {
+			continue
+		}
+		switch {
+		case entry.Type == StartEntry && entry.Reason == Crash:
+			mode.Crashes++
+		case entry.Type == StopEntry && entry.Reason == Crash && entry.Subsystem != "":
+			suspects[entry.Subsystem] = true
+		case entry.Type == SafeModeEntry:
+			// The crashes before were dealt with by this safe mode.
+			previous = entry.Disabled
+			inSafeMode = true
+			mode.Crashes = 0
+			suspects = map[Subsystem]bool{}
+		}
+	}
+	crashLoop := c.Threshold > 0 && mode.Crashes >= c.Threshold
+	if inSafeMode {
+		// Crashing in safe mode means what was turned off didn't help.
+		crashLoop = mode.Crashes > 0
+	}
+
+	disabled := map[Subsystem]bool{}
+	for _, subsystem := range previous {
+		disabled[subsystem] = true
+	}
+	if crashLoop {
+		suspected := false
+		for subsystem := range suspects {
+			if !disabled[subsystem] {
+				disabled[subsystem] = true
+				suspected = true
+			}
+		}
+		if !suspected {
+			for _, subsystem := range escalation {
+				if !disabled[subsystem] {
+					disabled[subsystem] = true
+					break
+				}
+			}
+		}
+	}
+	for _, subsystem := range escalation {
+		if disabled[subsystem] {
+			mode.Disabled = append(mode.Disabled, subsystem)
+		}
+	}
+	return mode
+}
@@ -0,0 +1,132 @@
+package bootjournal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func crashStart(minutes int) Entry {
+	return Entry{Type: StartEntry, Time: t0.Add(time.Duration(minutes) * time.Minute), Reason: Crash}
+}
+
+func TestCrashLoop(t *testing.T) {
+	detector := CrashLoop{Threshold: 3, Window: time.Hour}
+	now := t0.Add(2 * time.Hour)
+
+	for _, tc := range []struct {
+		name     string
+		entries  []Entry
+		crashes  int
+		disabled []Subsystem
+	}{
+		{
+			name:    "no crashes",
+			entries: []Entry{{Type: StartEntry, Time: t0.Add(110 * time.Minute), Reason: Signal}},
+		},
+		{
+			name: "crashes out of the window",
+			entries: []Entry{
+				crashStart(10), crashStart(20), crashStart(30),
+				crashStart(70), {Type: StartEntry, Time: t0.Add(80 * time.Minute), Reason: PowerLoss},
+			},
+			crashes: 1,
+		},
+		{
+			name:     "unknown cause",
+			entries:  []Entry{crashStart(70), crashStart(80), crashStart(90)},
+			crashes:  3,
+			disabled: []Subsystem{Publisher},
+		},
+		{
+			name: "sensor failed",
+			entries: []Entry{
+				crashStart(70),
+				{Type: StopEntry, Time: t0.Add(71 * time.Minute), Reason: Crash, Subsystem: Sensor},
+				crashStart(80), crashStart(90),
+			},
+			crashes:  3,
+			disabled: []Subsystem{Sensor},
+		},
+		{
+			name: "still crashing without the publisher",
+			entries: []Entry{
+				crashStart(70), crashStart(80), crashStart(90),
+				{Type: SafeModeEntry, Time: t0.Add(90 * time.Minute), Disabled: []Subsystem{Publisher}},
+				crashStart(100),
+			},
+			crashes:  1,
+			disabled: []Subsystem{Publisher, Sensor},
+		},
+		{
+			name: "clean restart in safe mode",
+			entries: []Entry{
+				crashStart(70), crashStart(80), crashStart(90),
+				{Type: SafeModeEntry, Time: t0.Add(90 * time.Minute), Disabled: []Subsystem{Publisher}},
+				{Type: StopEntry, Time: t0.Add(95 * time.Minute), Reason: Signal},
+				{Type: StartEntry, Time: t0.Add(100 * time.Minute), Reason: Signal},
+			},
+			disabled: []Subsystem{Publisher},
+		},
+		{
+			name: "sensor failed in safe mode",
+			entries: []Entry{
+				crashStart(70),
+				{Type: StopEntry, Time: t0.Add(75 * time.Minute), Reason: Crash, Subsystem: Publisher},
+				crashStart(80), crashStart(90),
+				{Type: SafeModeEntry, Time: t0.Add(90 * time.Minute), Disabled: []Subsystem{Publisher}},
+				{Type: StopEntry, Time: t0.Add(95 * time.Minute), Reason: Crash, Subsystem: Sensor},
+				crashStart(100),
+			},
+			crashes:  1,
+			disabled: []Subsystem{Publisher, Sensor},
+		},
+		{
+			name: "safe mode out of the window",
+			entries: []Entry{
+				crashStart(10), crashStart(20), crashStart(30),
+				{Type: SafeModeEntry, Time: t0.Add(30 * time.Minute), Disabled: []Subsystem{Publisher}},
+				{Type: StartEntry, Time: t0.Add(100 * time.Minute), Reason: Signal},
+			},
+		},
+		{
+			name: "nothing left to turn off",
+			entries: []Entry{
+				crashStart(70), crashStart(80), crashStart(90),
+				{Type: SafeModeEntry, Time: t0.Add(90 * time.Minute), Disabled: []Subsystem{Publisher, Sensor}},
+				crashStart(100),
+			},
+			crashes:  1,
+			disabled: []Subsystem{Publisher, Sensor},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mode := detector.Check(tc.entries, now)
+			assert.Equal(t, tc.crashes, mode.Crashes)
+			assert.Equal(t, tc.disabled, mode.Disabled)
+			assert.Equal(t, len(tc.disabled) > 0, mode.Enabled())
+		})
+	}
+}
+
+func TestSafeModeIsRecorded(t *testing.T) {
+	boot(t, "first")
+	path := t.TempDir() + "/journal"
+	detector := CrashLoop{Threshold: 2, Window: time.Hour}
+
+	j, _ := start(t, path, "v1", t0, false)
+	for i := 1; i <= 3; i++ {
+		var event BootEvent
+		j, event = start(t, path, "v1", t0.Add(time.Duration(i)*time.Minute), false)
+		assert.Equal(t, Crash, event.Reason)
+		mode := detector.Check(j.Entries(), t0.Add(time.Duration(i)*time.Minute))
+		if i == 1 {
+			assert.False(t, mode.Enabled())
+			continue
+		}
+		assert.NoError(t, j.EnterSafeMode(t0.Add(time.Duration(i)*time.Minute), mode.Disabled))
+		assert.True(t, mode.Disables(Publisher))
+		assert.Equal(t, i == 3, mode.Disables(Sensor))
+	}
+}
@@ -0,0 +1,36 @@
+package bootjournal
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/code-for-venezuela/poweroutage/pkg/events"
+	"github.com/code-for-venezuela/poweroutage/pkg/store"
+)
+
+// BootEventType is the event type of the boot events.
+const BootEventType = events.BootEventType
+
+// BootEvent is published on every start of the monitor.
+type BootEvent struct {
+	DeviceID string    `json:"device_id"`
+	BootedAt time.Time `json:"booted_at"`
+	Version  string    `json:"version"`
+	// Reason is why the previous run stopped.
+	Reason     Reason `json:"reason"`
+	OSRebooted bool   `json:"os_rebooted"`
+	// PreviousStart and PreviousStop bound the previous run. PreviousStop is
+	// only known when the monitor had the chance to record it.
+	PreviousStart *time.Time `json:"previous_start,omitempty"`
+	PreviousStop  *time.Time `json:"previous_stop,omitempty"`
+}
+
+// PublishBoot publishes event.
+func PublishBoot(publisher store.Publisher, event BootEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to serialize boot event: %v", err)
+	}
+	return publisher.Publish(BootEventType, payload)
+}
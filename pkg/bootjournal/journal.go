@@ -0,0 +1,291 @@
+package bootjournal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/code-for-venezuela/poweroutage/pkg/store"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Reason tells why the monitor stopped.
+type Reason string
+
+const (
+	// FirstBoot is the reason given when there is no previous run.
+	FirstBoot Reason = "first-boot"
+	// Signal is a clean stop on SIGTERM or SIGINT.
+	Signal Reason = "signal"
+	// Update is a stop followed by a start with a new version, usually a
+	// Balena update.
+	Update Reason = "update"
+	// Reboot is a reboot asked for by the rebooter.
+	Reboot Reason = "reboot"
+	// Crash is a log.Fatalf, a panic, or the process being killed.
+	Crash Reason = "crash"
+	// PowerLoss is the device going down without the monitor stopping.
+	PowerLoss Reason = "power-loss"
+	// BatteryDrained is a power loss during an outage, when the battery ran
+	// out.
+	BatteryDrained Reason = "battery-drained"
+)
+
+// EntryType is the type of a journal entry.
+type EntryType string
+
+const (
+	StartEntry EntryType = "start"
+	StopEntry  EntryType = "stop"
+	// SafeModeEntry records the subsystems disabled after a crash loop.
+	SafeModeEntry EntryType = "safe-mode"
+)
+
+// Entry is a line of the journal.
+type Entry struct {
+	Type EntryType `json:"type"`
+	Time time.Time `json:"time"`
+	// Reason is, on start entries, why the previous run stopped and, on stop
+	// entries, why this run is stopping.
+	Reason  Reason `json:"reason"`
+	Detail  string `json:"detail,omitempty"`
+	BootID  string `json:"boot_id,omitempty"`
+	Version string `json:"version,omitempty"`
+	// Subsystem is, on crash entries, the subsystem that failed when known.
+	Subsystem Subsystem `json:"subsystem,omitempty"`
+	// Disabled are the subsystems turned off by safe mode.
+	Disabled []Subsystem `json:"disabled,omitempty"`
+}
+
+// MaxEntries is how many entries are kept in the journal.
+const MaxEntries = 500
+
+// bootIDPath changes on every boot of the OS, even when read from a container.
+var bootIDPath = "/proc/sys/kernel/random/boot_id"
+
+// Journal is a file recording every start and stop of the monitor, with the
+// reason, so the next start can tell how the previous run ended.
+type Journal struct {
+	mu      sync.Mutex
+	path    string
+	version string
+	bootID  string
+	entries []Entry
+	// torn is set when the file has bad lines to drop on the next write.
+	torn bool
+}
+
+// Open reads the journal at path. version identifies the running build, a
+// change of version between runs is classified as an update.
+func Open(path, version string) (*Journal, error) {
+	j := &Journal{path: path, version: version}
+	if data, err := os.ReadFile(bootIDPath); err == nil {
+		j.bootID = strings.TrimSpace(string(data))
+	} else {
+		log.Warnf("could not read the boot ID, power losses will be reported as crashes: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading boot journal: %v", err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var entry Entry
+		// The last line is torn when power is cut while it is written.
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Warnf("skipping bad boot journal entry: %q", scanner.Text())
+			j.torn = true
+			continue
+		}
+		j.entries = append(j.entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading boot journal: %v", err)
+	}
+	return j, nil
+}
+
+// Entries returns the entries of the journal, oldest first.
+func (j *Journal) Entries() []Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]Entry(nil), j.entries...)
+}
+
+// Start classifies how the previous run stopped and records the start of
+// this one. ongoingOutage tells whether an outage was being recorded when the
+// previous run stopped.
+func (j *Journal) Start(at time.Time, ongoingOutage bool) (BootEvent, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	previous, stops := lastRun(j.entries)
+	current := Entry{Type: StartEntry, Time: at, BootID: j.bootID, Version: j.version}
+	current.Reason = classify(previous, stops, current, ongoingOutage)
+
+	event := BootEvent{
+		BootedAt: at,
+		Version:  j.version,
+		Reason:   current.Reason,
+	}
+	if previous != nil {
+		event.OSRebooted = previous.BootID != "" && previous.BootID != j.bootID
+		event.PreviousStart = &previous.Time
+		if len(stops) > 0 {
+			event.PreviousStop = &stops[len(stops)-1].Time
+		}
+	}
+
+	j.entries = append(j.entries, current)
+	if len(j.entries) > MaxEntries {
+		j.entries = j.entries[len(j.entries)-MaxEntries:]
+		j.torn = true
+	}
+	if j.torn {
+		j.torn = false
+		return event, j.rewrite()
+	}
+	return event, j.append(current)
+}
+
+// Stop records that the monitor is stopping for reason.
+func (j *Journal) Stop(at time.Time, reason Reason, detail string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry := Entry{Type: StopEntry, Time: at, Reason: reason, Detail: detail}
+	j.entries = append(j.entries, entry)
+	return j.append(entry)
+}
+
+// Stopped reports whether a stop was recorded since the last start.
+func (j *Journal) Stopped() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, stops := lastRun(j.entries)
+	return len(stops) > 0
+}
+
+// Crashed records that the monitor is stopping because subsystem failed.
+func (j *Journal) Crashed(at time.Time, subsystem Subsystem, detail string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry := Entry{Type: StopEntry, Time: at, Reason: Crash, Subsystem: subsystem, Detail: detail}
+	j.entries = append(j.entries, entry)
+	return j.append(entry)
+}
+
+// RecordPanic records that subsystem crashed when the function it is
+// deferred in panics, and panics again. It does nothing on a nil journal.
+func (j *Journal) RecordPanic(subsystem Subsystem) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if j != nil {
+		if err := j.Crashed(time.Now(), subsystem, fmt.Sprint(r)); err != nil {
+			log.Errorf("could not record the crash in the boot journal: %v", err)
+		}
+	}
+	panic(r)
+}
+
+// EnterSafeMode records that this run disabled the given subsystems.
+func (j *Journal) EnterSafeMode(at time.Time, disabled []Subsystem) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry := Entry{Type: SafeModeEntry, Time: at, Disabled: disabled}
+	j.entries = append(j.entries, entry)
+	return j.append(entry)
+}
+
+// lastRun returns the last start entry and the stop entries after it.
+func lastRun(entries []Entry) (*Entry, []Entry) {
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Type != StartEntry {
+			continue
+		}
+		var stops []Entry
+		for _, entry := range entries[i+1:] {
+			if entry.Type == StopEntry {
+				stops = append(stops, entry)
+			}
+		}
+		return &entries[i], stops
+	}
+	return nil, nil
+}
+
+func classify(previous *Entry, stops []Entry, current Entry, ongoingOutage bool) Reason {
+	if previous == nil {
+		return FirstBoot
+	}
+	rebooted := previous.BootID != "" && previous.BootID != current.BootID
+	var stop *Entry
+	for i := range stops {
+		// A reboot that didn't happen doesn't explain the stop.
+		if stops[i].Reason == Reboot && !rebooted {
+			continue
+		}
+		// The first reason recorded wins, e.g. the reboot over the SIGTERM
+		// the reboot causes.
+		if stop == nil {
+			stop = &stops[i]
+		}
+	}
+
+	switch {
+	case stop != nil && (stop.Reason == Reboot || stop.Reason == Crash):
+		return stop.Reason
+	case previous.Version != "" && previous.Version != current.Version:
+		return Update
+	case stop != nil:
+		return stop.Reason
+	case rebooted && ongoingOutage:
+		return BatteryDrained
+	case rebooted:
+		return PowerLoss
+	default:
+		// The process died without the OS rebooting.
+		return Crash
+	}
+}
+
+func (j *Journal) append(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error marshaling boot journal entry: %v", err)
+	}
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening boot journal: %v", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing boot journal: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("error syncing boot journal: %v", err)
+	}
+	return f.Close()
+}
+
+func (j *Journal) rewrite() error {
+	var buf bytes.Buffer
+	for _, entry := range j.entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("error marshaling boot journal entry: %v", err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return store.WriteFileAtomic(j.path, buf.Bytes(), 0644)
+}
@@ -0,0 +1,142 @@
+package bootjournal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var t0 = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// boot makes the journal see the OS with the given boot ID.
+func boot(t *testing.T, id string) {
+	path := filepath.Join(t.TempDir(), "boot_id")
+	require.NoError(t, os.WriteFile(path, []byte(id+"\n"), 0644))
+	previous := bootIDPath
+	bootIDPath = path
+	t.Cleanup(func() { bootIDPath = previous })
+}
+
+func start(t *testing.T, path, version string, at time.Time, ongoingOutage bool) (*Journal, BootEvent) {
+	j, err := Open(path, version)
+	require.NoError(t, err)
+	event, err := j.Start(at, ongoingOutage)
+	require.NoError(t, err)
+	return j, event
+}
+
+func TestClassify(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		stops         []Reason
+		rebooted      bool
+		newVersion    bool
+		ongoingOutage bool
+		expected      Reason
+	}{
+		{name: "sigterm", stops: []Reason{Signal}, expected: Signal},
+		{name: "sigterm and reboot", stops: []Reason{Signal}, rebooted: true, expected: Signal},
+		{name: "update", stops: []Reason{Signal}, newVersion: true, expected: Update},
+		{name: "update killed", newVersion: true, expected: Update},
+		{name: "rebooter", stops: []Reason{Reboot, Signal}, rebooted: true, expected: Reboot},
+		{name: "failed reboot", stops: []Reason{Reboot, Signal}, expected: Signal},
+		{name: "fatal", stops: []Reason{Crash}, expected: Crash},
+		{name: "fatal before update", stops: []Reason{Crash}, newVersion: true, expected: Crash},
+		{name: "killed", expected: Crash},
+		{name: "power cut", rebooted: true, expected: PowerLoss},
+		{name: "battery drained", rebooted: true, ongoingOutage: true, expected: BatteryDrained},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "journal")
+			boot(t, "first")
+			j, event := start(t, path, "v1", t0, false)
+			assert.Equal(t, FirstBoot, event.Reason)
+			for i, reason := range tc.stops {
+				require.NoError(t, j.Stop(t0.Add(time.Duration(i+1)*time.Minute), reason, ""))
+			}
+
+			if tc.rebooted {
+				boot(t, "second")
+			}
+			version := "v1"
+			if tc.newVersion {
+				version = "v2"
+			}
+			_, event = start(t, path, version, t0.Add(time.Hour), tc.ongoingOutage)
+			assert.Equal(t, tc.expected, event.Reason)
+			assert.Equal(t, tc.rebooted, event.OSRebooted)
+			require.NotNil(t, event.PreviousStart)
+			assert.True(t, t0.Equal(*event.PreviousStart))
+			if len(tc.stops) > 0 {
+				require.NotNil(t, event.PreviousStop)
+				assert.True(t, t0.Add(time.Duration(len(tc.stops))*time.Minute).Equal(*event.PreviousStop))
+			} else {
+				assert.Nil(t, event.PreviousStop)
+			}
+		})
+	}
+}
+
+func TestJournalSkipsTornEntry(t *testing.T) {
+	boot(t, "first")
+	path := filepath.Join(t.TempDir(), "journal")
+	j, _ := start(t, path, "v1", t0, false)
+	require.NoError(t, j.Stop(t0.Add(time.Minute), Signal, ""))
+
+	// Power is cut while the next stop is written.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"type":"stop","ti`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	j, event := start(t, path, "v1", t0.Add(time.Hour), false)
+	assert.Equal(t, Signal, event.Reason)
+	assert.False(t, j.Stopped())
+	require.NoError(t, j.Crashed(t0.Add(2*time.Hour), Sensor, "boom"))
+	assert.True(t, j.Stopped())
+
+	j, err = Open(path, "v1")
+	require.NoError(t, err)
+	entries := j.Entries()
+	require.Len(t, entries, 4)
+	assert.Equal(t, Entry{Type: StopEntry, Time: t0.Add(2 * time.Hour), Reason: Crash, Subsystem: Sensor, Detail: "boom"}, entries[3])
+}
+
+func TestJournalKeepsMaxEntries(t *testing.T) {
+	boot(t, "first")
+	path := filepath.Join(t.TempDir(), "journal")
+	for i := 0; i < MaxEntries+10; i++ {
+		start(t, path, "v1", t0.Add(time.Duration(i)*time.Minute), false)
+	}
+	j, err := Open(path, "v1")
+	require.NoError(t, err)
+	entries := j.Entries()
+	require.Len(t, entries, MaxEntries)
+	assert.True(t, t0.Add(10*time.Minute).Equal(entries[0].Time))
+}
+
+func TestRecordPanic(t *testing.T) {
+	boot(t, "first")
+	path := filepath.Join(t.TempDir(), "journal")
+	j, _ := start(t, path, "v1", t0, false)
+
+	assert.PanicsWithValue(t, "i2c bus gone", func() {
+		defer j.RecordPanic(Sensor)
+		panic("i2c bus gone")
+	})
+	assert.True(t, j.Stopped())
+	entries := j.Entries()
+	assert.Equal(t, Sensor, entries[len(entries)-1].Subsystem)
+	assert.Equal(t, "i2c bus gone", entries[len(entries)-1].Detail)
+
+	var disabled *Journal
+	assert.Panics(t, func() {
+		defer disabled.RecordPanic(Publisher)
+		panic("boom")
+	})
+}
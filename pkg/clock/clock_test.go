@@ -0,0 +1,42 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeTicker(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFake(start)
+	ticker := clock.NewTicker(time.Minute)
+
+	clock.Advance(30 * time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticked too early")
+	default:
+	}
+
+	clock.Advance(30 * time.Second)
+	assert.Equal(t, start.Add(time.Minute), <-ticker.C())
+	assert.Equal(t, start.Add(time.Minute), clock.Now())
+
+	// Ticks that aren't received are dropped.
+	clock.Advance(3 * time.Minute)
+	assert.Equal(t, start.Add(2*time.Minute), <-ticker.C())
+	select {
+	case <-ticker.C():
+		t.Fatal("ticks should have been dropped")
+	default:
+	}
+
+	ticker.Stop()
+	clock.Advance(time.Hour)
+	select {
+	case <-ticker.C():
+		t.Fatal("stopped tickers don't tick")
+	default:
+	}
+}
@@ -0,0 +1,23 @@
+// Package events names the messages the monitors publish. It is shared by the
+// monitors and the servers that receive their messages, and has no
+// dependencies so the servers don't pull in the code of the device.
+package events
+
+// Event types of the messages.
+const (
+	// ProbeEventType is the event type of the keep alive probes.
+	ProbeEventType = "power_outage_probe"
+	// OutageEventType is the event type of the outage events sent by the
+	// publishers that, unlike MySQL, have no table of their own for them.
+	OutageEventType = "power_outage_incident"
+	// BootEventType is the event type of the boot events.
+	BootEventType = "power_outage_boot"
+	// SensorFaultEventType is the event type of the sensor fault events.
+	SensorFaultEventType = "power_outage_sensor_fault"
+)
+
+// Statuses of the sensor fault events.
+const (
+	SensorFault     = "fault"
+	SensorRecovered = "recovered"
+)
@@ -5,12 +5,21 @@ import (
 	"encoding/json"
 	"errors"
 	"time"
+
+	"github.com/code-for-venezuela/poweroutage/pkg/telemetry"
 )
 
 type Event struct {
 	DeviceID string    `json:"device_id"`
 	Status   string    `json:"status"`
 	SentAt   time.Time `json:"sent_at"`
+	// Reason is why the previous run stopped, sent with the restarting
+	// probe.
+	Reason string `json:"reason,omitempty"`
+	// State is the state of the monitor, e.g. "outage".
+	State string `json:"state,omitempty"`
+	// Telemetry is absent from the probes of older monitors.
+	Telemetry *telemetry.Snapshot `json:"telemetry,omitempty"`
 }
 
 // GetEventsForDevice retrieves events for a specific device within the last 2 days.
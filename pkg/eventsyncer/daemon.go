@@ -10,6 +10,12 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// Drainer is implemented by the publishers that queue messages, such as the
+// outbox, and deliver them when drained.
+type Drainer interface {
+	Drain(ctx context.Context) error
+}
+
 type EventSyncer struct {
 	recorder  store.OutageRecorder
 	publisher store.Publisher
@@ -33,6 +39,8 @@ func (es *EventSyncer) Close() error {
 	return nil
 }
 
+// Run syncs the finished events every interval until ctx is done. A sync in
+// progress when ctx is done stops after the event being published.
 func (es *EventSyncer) Run(ctx context.Context) error {
 	for {
 		select {
@@ -40,22 +48,45 @@ func (es *EventSyncer) Run(ctx context.Context) error {
 			return es.Close()
 
 		case <-es.t.C:
-			fileName, events, err := es.recorder.GetFinishedEvents()
-			if err != nil {
-				return errors.Wrapf(err, "error reading finished events")
-			}
-			for i, event := range events {
-				log.Infof("publishing event: %v", event)
-				err := es.publisher.PublishOutageEvent(event)
-				if err != nil {
-					log.Warnf("Could not publish event: %v. Will retry later", event)
-					continue
-				}
-				err = es.recorder.DeleteEventFile(fileName[i])
-				if err != nil {
-					log.Warnf("Could not publish event: %v. Will retry later", event)
-				}
+			if err := es.Sync(ctx); err != nil {
+				return err
 			}
 		}
 	}
 }
+
+// Sync publishes the finished events and deletes the ones that were
+// published, including the ones published by an earlier sync that didn't get
+// to delete them. When the publisher is a Drainer, it is drained afterwards,
+// so the finished events are delivered along with the rest of its queue. It
+// returns early, without error, when ctx is done.
+func (es *EventSyncer) Sync(ctx context.Context) error {
+	fileName, events, err := es.recorder.GetFinishedEvents()
+	if err != nil {
+		return errors.Wrapf(err, "error reading finished events")
+	}
+	for i, event := range events {
+		if ctx.Err() != nil {
+			log.Infof("shutting down, %v events left to sync", len(events)-i)
+			return nil
+		}
+		log.Infof("publishing event: %v", event)
+		err := es.publisher.PublishOutageEvent(event)
+		if errors.Is(err, store.ErrAlreadyPublished) {
+			log.Infof("event %v was already published", event.ID)
+		} else if err != nil {
+			log.Warnf("Could not publish event: %v. Will retry later", event)
+			continue
+		}
+		err = es.recorder.DeleteEventFile(fileName[i])
+		if err != nil {
+			log.Warnf("Could not delete published event file %v: %v. Will retry later", fileName[i], err)
+		}
+	}
+	if drainer, ok := es.publisher.(Drainer); ok {
+		if err := drainer.Drain(ctx); err != nil {
+			log.Warnf("Could not drain the outbox: %v. Will retry later", err)
+		}
+	}
+	return nil
+}
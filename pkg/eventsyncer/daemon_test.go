@@ -0,0 +1,152 @@
+package eventsyncer
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/code-for-venezuela/poweroutage/pkg/outbox"
+	"github.com/code-for-venezuela/poweroutage/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cancellingPublisher cancels the sync after publishing a number of events.
+type cancellingPublisher struct {
+	published []store.OutageEvent
+	after     int
+	cancel    context.CancelFunc
+}
+
+func (p *cancellingPublisher) Publish(eventType string, payload []byte) error {
+	return nil
+}
+
+func (p *cancellingPublisher) PublishOutageEvent(event store.OutageEvent) error {
+	p.published = append(p.published, event)
+	if len(p.published) == p.after {
+		p.cancel()
+	}
+	return nil
+}
+
+func (p *cancellingPublisher) Close() error {
+	return nil
+}
+
+func newRecorderWithFinishedEvents(t *testing.T, count int) store.OutageRecorder {
+	dir := t.TempDir()
+	recorder, err := store.NewFileSystemRecorder("test", filepath.Join(dir, "events"), filepath.Join(dir, "finished"))
+	require.NoError(t, err)
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < count; i++ {
+		// Event files are named after the start time in seconds.
+		at := start.Add(time.Duration(i) * time.Hour)
+		_, err := recorder.StartIncident(at)
+		require.NoError(t, err)
+		_, err = recorder.FinishIncident(at.Add(time.Minute))
+		require.NoError(t, err)
+	}
+	return recorder
+}
+
+func TestSyncStopsWhenCancelled(t *testing.T) {
+	recorder := newRecorderWithFinishedEvents(t, 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	publisher := &cancellingPublisher{after: 1, cancel: cancel}
+
+	syncer := NewEventSyncer(time.Minute, recorder, publisher)
+	defer syncer.Close()
+	require.NoError(t, syncer.Sync(ctx))
+
+	// The event in flight finishes, the rest stays for the next start.
+	assert.Len(t, publisher.published, 1)
+	_, left, err := recorder.GetFinishedEvents()
+	require.NoError(t, err)
+	assert.Len(t, left, 2)
+
+	require.NoError(t, syncer.Sync(context.Background()))
+	_, left, err = recorder.GetFinishedEvents()
+	require.NoError(t, err)
+	assert.Empty(t, left)
+}
+
+func TestRunReturnsWhenCancelled(t *testing.T) {
+	recorder := newRecorderWithFinishedEvents(t, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	syncer := NewEventSyncer(time.Hour, recorder, &cancellingPublisher{cancel: cancel})
+
+	done := make(chan error)
+	go func() { done <- syncer.Run(ctx) }()
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return after the context was cancelled")
+	}
+}
+
+// publishedPublisher has published every event before.
+type publishedPublisher struct {
+	cancellingPublisher
+}
+
+func (p *publishedPublisher) PublishOutageEvent(event store.OutageEvent) error {
+	return store.ErrAlreadyPublished
+}
+
+func TestSyncDeletesEventsAlreadyPublished(t *testing.T) {
+	recorder := newRecorderWithFinishedEvents(t, 2)
+	syncer := NewEventSyncer(time.Minute, recorder, &publishedPublisher{})
+	defer syncer.Close()
+
+	require.NoError(t, syncer.Sync(context.Background()))
+	_, left, err := recorder.GetFinishedEvents()
+	require.NoError(t, err)
+	assert.Empty(t, left)
+}
+
+// flakyPublisher fails the first failures calls, then succeeds.
+type flakyPublisher struct {
+	cancellingPublisher
+	failures int
+	calls    int
+}
+
+func (p *flakyPublisher) PublishOutageEvent(event store.OutageEvent) error {
+	p.calls++
+	if p.calls <= p.failures {
+		return errors.New("network is unreachable")
+	}
+	p.published = append(p.published, event)
+	return nil
+}
+
+func TestSyncDrainsOutbox(t *testing.T) {
+	recorder := newRecorderWithFinishedEvents(t, 2)
+	publisher := &flakyPublisher{failures: 2}
+	queue, err := outbox.Open(t.TempDir(), publisher, outbox.Config{})
+	require.NoError(t, err)
+	syncer := NewEventSyncer(time.Minute, recorder, queue)
+	defer syncer.Close()
+
+	// The finished events move to the outbox, which keeps them until the
+	// publisher is back.
+	for i := 0; i < 2; i++ {
+		require.NoError(t, syncer.Sync(context.Background()))
+		_, left, err := recorder.GetFinishedEvents()
+		require.NoError(t, err)
+		assert.Empty(t, left)
+		assert.Equal(t, 2, queue.Len())
+		assert.Empty(t, publisher.published)
+	}
+
+	require.NoError(t, syncer.Sync(context.Background()))
+	assert.Zero(t, queue.Len())
+	require.Len(t, publisher.published, 2)
+	assert.True(t, publisher.published[0].StartTime.Before(publisher.published[1].StartTime))
+}
@@ -0,0 +1,133 @@
+// Package ingest receives the messages of the devices over HTTP, so they
+// don't need credentials to the database. Devices post the envelopes of the
+// Angostura publisher and authenticate with a token of their own.
+package ingest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/code-for-venezuela/poweroutage/pkg/store"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MaxBodyBytes is the largest request accepted.
+const MaxBodyBytes = 64 << 10
+
+// Server handles the requests of the devices, writing the messages to a
+// publisher, normally the MySQL one.
+type Server struct {
+	publisher store.Publisher
+	tokens    *Tokens
+	// APIKeyHeader is where devices may send their token instead of the
+	// Authorization header.
+	APIKeyHeader string
+}
+
+// NewServer returns a server writing to publisher the messages of the
+// devices with a token in tokens.
+func NewServer(publisher store.Publisher, tokens *Tokens) *Server {
+	return &Server{publisher: publisher, tokens: tokens, APIKeyHeader: store.DefaultAPIKeyHeader}
+}
+
+// Handler returns the routes of the server: POST /events takes the messages
+// and GET /healthz tells whether the server is up.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", s.handleEvent)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return mux
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// fail answers with status and message. Devices send again the messages
+// that failed with a 5xx, 401 or 403 status, and drop the rest.
+func fail(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: message})
+}
+
+func (s *Server) handleEvent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		fail(w, http.StatusMethodNotAllowed, "only POST is allowed")
+		return
+	}
+	device, ok := s.tokens.Device(s.token(r))
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		fail(w, http.StatusUnauthorized, "missing or unknown device token")
+		return
+	}
+
+	var envelope store.Envelope
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxBodyBytes))
+	if err := decoder.Decode(&envelope); err != nil {
+		if strings.Contains(err.Error(), "request body too large") {
+			fail(w, http.StatusRequestEntityTooLarge, "the message is too large")
+			return
+		}
+		fail(w, http.StatusBadRequest, fmt.Sprintf("invalid envelope: %v", err))
+		return
+	}
+	if envelope.Version != store.EnvelopeVersion {
+		fail(w, http.StatusBadRequest, fmt.Sprintf("unsupported envelope version %q", envelope.Version))
+		return
+	}
+
+	message, err := validate(device, envelope)
+	if errors.Is(err, errWrongDevice) {
+		fail(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		fail(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if message.outage != nil {
+		err = s.publisher.PublishOutageEvent(*message.outage)
+		// Devices send an event again when they didn't hear back.
+		if errors.Is(err, store.ErrAlreadyPublished) {
+			err = nil
+		}
+		// The ID of an outage is public, it is no proof of owning it.
+		if errors.Is(err, store.ErrOtherDevice) {
+			fail(w, http.StatusConflict, "the outage event belongs to another device")
+			return
+		}
+	} else {
+		err = s.publisher.Publish(envelope.Type, envelope.Payload)
+	}
+	if err != nil {
+		log.Errorf("failed to write %v from %v: %v", envelope.Type, device, err)
+		fail(w, http.StatusInternalServerError, "failed to store the message")
+		return
+	}
+	log.Debugf("stored %v from %v", envelope.Type, device)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// token returns the token in the Authorization header, or in APIKeyHeader.
+func (s *Server) token(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+			return strings.TrimSpace(auth[len("Bearer "):])
+		}
+		return ""
+	}
+	if s.APIKeyHeader != "" {
+		return r.Header.Get(s.APIKeyHeader)
+	}
+	return ""
+}
@@ -0,0 +1,251 @@
+package ingest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/code-for-venezuela/poweroutage/pkg/events"
+	"github.com/code-for-venezuela/poweroutage/pkg/eventsreader"
+	"github.com/code-for-venezuela/poweroutage/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type event struct {
+	eventType string
+	payload   []byte
+}
+
+type fakePublisher struct {
+	mu      sync.Mutex
+	events  []event
+	outages []store.OutageEvent
+	err     error
+}
+
+func (p *fakePublisher) Publish(eventType string, payload []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.events = append(p.events, event{eventType, payload})
+	return nil
+}
+
+func (p *fakePublisher) PublishOutageEvent(e store.OutageEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	for _, published := range p.outages {
+		if published.ID == e.ID && published.DeviceId != e.DeviceId {
+			return store.ErrOtherDevice
+		}
+		if published == e {
+			return store.ErrAlreadyPublished
+		}
+	}
+	p.outages = append(p.outages, e)
+	return nil
+}
+
+func (p *fakePublisher) Close() error {
+	return nil
+}
+
+// newTestServer serves the messages of monitor-1, with token secret-1, and
+// monitor-2, with token secret-2.
+func newTestServer(t *testing.T) (*httptest.Server, *fakePublisher) {
+	tokens, err := ParseTokens(strings.NewReader(
+		"monitor-1 " + HashToken("secret-1") + "\nmonitor-2 " + HashToken("secret-2") + "\n",
+	))
+	require.NoError(t, err)
+	publisher := &fakePublisher{}
+	server := httptest.NewServer(NewServer(publisher, tokens).Handler())
+	t.Cleanup(server.Close)
+	return server, publisher
+}
+
+func newUploader(server *httptest.Server, token string) *store.AngosturaUploader {
+	return store.NewAngosturaUploader(store.AngosturaConfig{Endpoint: server.URL + "/events", BearerToken: token})
+}
+
+func outage(status store.State) store.OutageEvent {
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	event := store.OutageEvent{
+		ID:        "0b0e6e5e-7f6b-4b8c-9d1f-3c2a1e0f9a77",
+		Status:    status,
+		StartTime: start,
+		DeviceId:  "monitor-1",
+	}
+	if status == store.Resolved {
+		event.EndTime = start.Add(time.Hour)
+	}
+	return event
+}
+
+func TestIngestStoresMessagesOfDevices(t *testing.T) {
+	server, publisher := newTestServer(t)
+	uploader := newUploader(server, "secret-1")
+
+	probe, err := json.Marshal(eventsreader.Event{DeviceID: "monitor-1", Status: "healthy", SentAt: time.Now()})
+	require.NoError(t, err)
+	require.NoError(t, uploader.Publish(events.ProbeEventType, probe))
+	require.NoError(t, uploader.PublishOutageEvent(outage(store.Ongoing)))
+	require.NoError(t, uploader.PublishOutageEvent(outage(store.Resolved)))
+	// Sent again after a lost response.
+	require.NoError(t, uploader.PublishOutageEvent(outage(store.Resolved)))
+
+	require.Len(t, publisher.events, 1)
+	assert.Equal(t, events.ProbeEventType, publisher.events[0].eventType)
+	assert.JSONEq(t, string(probe), string(publisher.events[0].payload))
+	assert.Equal(t, []store.OutageEvent{outage(store.Ongoing), outage(store.Resolved)}, publisher.outages)
+}
+
+func TestIngestStoresOutageThatOutlastedTheBattery(t *testing.T) {
+	server, publisher := newTestServer(t)
+	event := outage(store.Resolved)
+	lostPowerAt := event.StartTime.Add(20 * time.Minute)
+	event.DeviceLostPowerAt = &lostPowerAt
+
+	require.NoError(t, newUploader(server, "secret-1").PublishOutageEvent(event))
+	require.Len(t, publisher.outages, 1)
+	require.NotNil(t, publisher.outages[0].DeviceLostPowerAt)
+	assert.True(t, lostPowerAt.Equal(*publisher.outages[0].DeviceLostPowerAt))
+}
+
+func TestIngestKeepsOutagesOfOtherDevices(t *testing.T) {
+	server, publisher := newTestServer(t)
+	require.NoError(t, newUploader(server, "secret-1").PublishOutageEvent(outage(store.Ongoing)))
+
+	// monitor-2 knows the ID of the outage of monitor-1, e.g. from Pusher.
+	event := outage(store.Resolved)
+	event.DeviceId = "monitor-2"
+	err := newUploader(server, "secret-2").PublishOutageEvent(event)
+	var httpErr *store.HTTPError
+	require.True(t, errors.As(err, &httpErr), "%v", err)
+	assert.Equal(t, http.StatusConflict, httpErr.StatusCode)
+	assert.True(t, store.IsPermanent(err))
+	assert.Equal(t, []store.OutageEvent{outage(store.Ongoing)}, publisher.outages)
+}
+
+func TestIngestAuthenticatesDevices(t *testing.T) {
+	server, publisher := newTestServer(t)
+	probe, err := json.Marshal(eventsreader.Event{DeviceID: "monitor-1", Status: "healthy", SentAt: time.Now()})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		uploader *store.AngosturaUploader
+		status   int
+	}{
+		{"no token", newUploader(server, ""), http.StatusUnauthorized},
+		{"unknown token", newUploader(server, "guess"), http.StatusUnauthorized},
+		{"token of another device", newUploader(server, "secret-2"), http.StatusForbidden},
+		{"api key", store.NewAngosturaUploader(store.AngosturaConfig{Endpoint: server.URL + "/events", APIKey: "secret-1"}), 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.uploader.Publish(events.ProbeEventType, probe)
+			if test.status == 0 {
+				require.NoError(t, err)
+				return
+			}
+			var httpErr *store.HTTPError
+			require.True(t, errors.As(err, &httpErr), "%v", err)
+			assert.Equal(t, test.status, httpErr.StatusCode)
+			// The device keeps the message until its credentials are fixed.
+			assert.False(t, store.IsPermanent(err))
+		})
+	}
+	assert.Len(t, publisher.events, 1)
+}
+
+func TestIngestRejectsInvalidMessages(t *testing.T) {
+	server, publisher := newTestServer(t)
+	uploader := newUploader(server, "secret-1")
+
+	noEnd := outage(store.Resolved)
+	noEnd.EndTime = time.Time{}
+	badID := outage(store.Ongoing)
+	badID.ID = "outage-1"
+	lostPowerAfterEnd := outage(store.Resolved)
+	afterEnd := lostPowerAfterEnd.EndTime.Add(time.Minute)
+	lostPowerAfterEnd.DeviceLostPowerAt = &afterEnd
+	tests := []struct {
+		name      string
+		eventType string
+		payload   string
+	}{
+		{"unknown type", "power_outage_unknown", `{}`},
+		{"not json", events.ProbeEventType, `healthy`},
+		{"not an object", events.ProbeEventType, `[]`},
+		{"probe without status", events.ProbeEventType, `{"device_id":"monitor-1","sent_at":"2024-03-01T10:00:00Z"}`},
+		{"invalid outage status", store.OutageEventType, `{"id":"0b0e6e5e-7f6b-4b8c-9d1f-3c2a1e0f9a77","status":"maybe","device_id":"monitor-1"}`},
+		{"resolved outage without end", store.OutageEventType, mustMarshal(t, noEnd)},
+		{"outage with invalid id", store.OutageEventType, mustMarshal(t, badID)},
+		{"lost power after the outage ended", store.OutageEventType, mustMarshal(t, lostPowerAfterEnd)},
+		{"invalid sensor fault", events.SensorFaultEventType, `{"device_id":"monitor-1","status":"on fire"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := uploader.Publish(test.eventType, []byte(test.payload))
+			require.Error(t, err)
+			assert.True(t, store.IsPermanent(err), "%v", err)
+		})
+	}
+	assert.Empty(t, publisher.events)
+	assert.Empty(t, publisher.outages)
+}
+
+func TestIngestRequests(t *testing.T) {
+	server, _ := newTestServer(t)
+	post := func(body string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/events", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer secret-1")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	assert.Equal(t, http.StatusBadRequest, post(`{"type":"power_outage_probe","version":"2","payload":"e30="}`).StatusCode)
+	assert.Equal(t, http.StatusBadRequest, post(`{"type":`).StatusCode)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post(`{"type":"`+strings.Repeat("x", MaxBodyBytes)+`"}`).StatusCode)
+
+	resp, err := http.Get(server.URL + "/events")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	resp, err = http.Get(server.URL + "/healthz")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestIngestStorageFailure(t *testing.T) {
+	server, publisher := newTestServer(t)
+	publisher.err = errors.New("database is down")
+
+	err := newUploader(server, "secret-1").PublishOutageEvent(outage(store.Ongoing))
+	var httpErr *store.HTTPError
+	require.True(t, errors.As(err, &httpErr))
+	assert.Equal(t, http.StatusInternalServerError, httpErr.StatusCode)
+	assert.False(t, store.IsPermanent(err))
+	assert.NotContains(t, httpErr.Body, "database is down")
+}
+
+func mustMarshal(t *testing.T, v interface{}) string {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return string(data)
+}
@@ -0,0 +1,93 @@
+package ingest
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Tokens authenticates the devices. Only the SHA-256 of each token is kept,
+// so the tokens file doesn't give away the tokens of the devices.
+type Tokens struct {
+	// devices maps the hash of a token to its device.
+	devices map[string]string
+}
+
+// LoadTokens reads the tokens file at path. See ParseTokens.
+func LoadTokens(path string) (*Tokens, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseTokens(f)
+}
+
+// ParseTokens reads a line per device with its ID and the hash of its token,
+// as printed by HashToken, separated by spaces. Empty lines and lines starting
+// with # are skipped.
+func ParseTokens(r io.Reader) (*Tokens, error) {
+	tokens := &Tokens{devices: map[string]string{}}
+	devices := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %v: expected a device ID and a token hash", line)
+		}
+		device, hash := fields[0], strings.ToLower(fields[1])
+		if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("line %v: %q is not a SHA-256 hash", line, fields[1])
+		}
+		if devices[device] {
+			return nil, fmt.Errorf("line %v: duplicate device %v", line, device)
+		}
+		if _, ok := tokens.devices[hash]; ok {
+			return nil, fmt.Errorf("line %v: device %v shares its token", line, device)
+		}
+		devices[device] = true
+		tokens.devices[hash] = device
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Device returns the device token belongs to.
+func (t *Tokens) Device(token string) (string, bool) {
+	if token == "" {
+		return "", false
+	}
+	device, ok := t.devices[HashToken(token)]
+	return device, ok
+}
+
+// Len returns how many devices have a token.
+func (t *Tokens) Len() int {
+	return len(t.devices)
+}
+
+// HashToken returns the hash of token kept in the tokens file.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewToken returns a random token.
+func NewToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,57 @@
+package ingest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTokens(t *testing.T) {
+	tokens, err := ParseTokens(strings.NewReader(
+		"# device token hash\n" +
+			"monitor-1 " + HashToken("first") + "\n" +
+			"\n" +
+			"  monitor-2   " + strings.ToUpper(HashToken("second")) + "  \n",
+	))
+	require.NoError(t, err)
+	assert.Equal(t, 2, tokens.Len())
+
+	device, ok := tokens.Device("first")
+	assert.True(t, ok)
+	assert.Equal(t, "monitor-1", device)
+	device, ok = tokens.Device("second")
+	assert.True(t, ok)
+	assert.Equal(t, "monitor-2", device)
+
+	_, ok = tokens.Device("third")
+	assert.False(t, ok)
+	_, ok = tokens.Device("")
+	assert.False(t, ok)
+	// The file holds hashes, not tokens.
+	_, ok = tokens.Device(HashToken("first"))
+	assert.False(t, ok)
+}
+
+func TestParseTokensErrors(t *testing.T) {
+	for _, file := range []string{
+		"monitor-1\n",
+		"monitor-1 secret\n",
+		"monitor-1 " + HashToken("a") + " extra\n",
+		"monitor-1 " + HashToken("a") + "\nmonitor-1 " + HashToken("b") + "\n",
+		"monitor-1 " + HashToken("a") + "\nmonitor-2 " + HashToken("a") + "\n",
+	} {
+		_, err := ParseTokens(strings.NewReader(file))
+		assert.Error(t, err, file)
+	}
+}
+
+func TestNewToken(t *testing.T) {
+	first, err := NewToken()
+	require.NoError(t, err)
+	second, err := NewToken()
+	require.NoError(t, err)
+	assert.Len(t, first, 64)
+	assert.NotEqual(t, first, second)
+}
@@ -0,0 +1,131 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/code-for-venezuela/poweroutage/pkg/events"
+	"github.com/code-for-venezuela/poweroutage/pkg/eventsreader"
+	"github.com/code-for-venezuela/poweroutage/pkg/store"
+	"github.com/google/uuid"
+)
+
+// errWrongDevice is returned when a device sends a message of another one.
+var errWrongDevice = errors.New("the message belongs to another device")
+
+// message is a validated message. outage is set on outage events, which have
+// a table of their own.
+type message struct {
+	outage *store.OutageEvent
+}
+
+// bootEvent holds the fields of the boot events that are checked.
+type bootEvent struct {
+	DeviceID string    `json:"device_id"`
+	BootedAt time.Time `json:"booted_at"`
+}
+
+// sensorFaultEvent holds the fields of the sensor fault events that are
+// checked.
+type sensorFaultEvent struct {
+	DeviceID string `json:"device_id"`
+	Status   string `json:"status"`
+}
+
+// validate checks that the payload of envelope follows the schema of its
+// type and was sent by device.
+func validate(device string, envelope store.Envelope) (message, error) {
+	switch envelope.Type {
+	case events.ProbeEventType:
+		var probe eventsreader.Event
+		if err := decode(envelope, &probe); err != nil {
+			return message{}, err
+		}
+		if probe.Status == "" || probe.SentAt.IsZero() {
+			return message{}, errors.New("probes need a status and sent_at")
+		}
+		return message{}, sameDevice(device, probe.DeviceID)
+	case events.OutageEventType:
+		var event store.OutageEvent
+		if err := decode(envelope, &event); err != nil {
+			return message{}, err
+		}
+		if err := validateOutage(event); err != nil {
+			return message{}, err
+		}
+		if err := sameDevice(device, event.DeviceId); err != nil {
+			return message{}, err
+		}
+		return message{outage: &event}, nil
+	case events.BootEventType:
+		var boot bootEvent
+		if err := decode(envelope, &boot); err != nil {
+			return message{}, err
+		}
+		if boot.BootedAt.IsZero() {
+			return message{}, errors.New("boot events need booted_at")
+		}
+		return message{}, sameDevice(device, boot.DeviceID)
+	case events.SensorFaultEventType:
+		var fault sensorFaultEvent
+		if err := decode(envelope, &fault); err != nil {
+			return message{}, err
+		}
+		if fault.Status != events.SensorFault && fault.Status != events.SensorRecovered {
+			return message{}, fmt.Errorf("invalid sensor fault status %q", fault.Status)
+		}
+		return message{}, sameDevice(device, fault.DeviceID)
+	default:
+		return message{}, fmt.Errorf("unknown event type %q", envelope.Type)
+	}
+}
+
+// decode unmarshals the payload of envelope, which must be a JSON object.
+// Unknown fields are allowed, newer devices may send more.
+func decode(envelope store.Envelope, v interface{}) error {
+	if !bytes.HasPrefix(bytes.TrimSpace(envelope.Payload), []byte("{")) {
+		return fmt.Errorf("the payload of %v is not a JSON object", envelope.Type)
+	}
+	if err := json.Unmarshal(envelope.Payload, v); err != nil {
+		return fmt.Errorf("invalid %v payload: %v", envelope.Type, err)
+	}
+	return nil
+}
+
+func validateOutage(event store.OutageEvent) error {
+	// The column holds the 36 characters of the canonical form.
+	if _, err := uuid.Parse(event.ID); err != nil || len(event.ID) != 36 {
+		return fmt.Errorf("invalid outage event ID %q", event.ID)
+	}
+	if event.StartTime.IsZero() {
+		return errors.New("outage events need start_time")
+	}
+	switch event.Status {
+	case store.Ongoing:
+		if event.DeviceLostPowerAt != nil {
+			return errors.New("ongoing outage events can't have device_lost_power_at")
+		}
+		return nil
+	case store.Resolved:
+		if event.EndTime.Before(event.StartTime) {
+			return errors.New("resolved outage events need an end_time after start_time")
+		}
+		// The outage ended between device_lost_power_at and end_time.
+		if event.DeviceLostPowerAt != nil && event.DeviceLostPowerAt.After(event.EndTime) {
+			return errors.New("device_lost_power_at has to be before end_time")
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid outage event status %v", event.Status)
+	}
+}
+
+func sameDevice(device, sender string) error {
+	if sender != device {
+		return fmt.Errorf("%w: %q is authenticated as %q", errWrongDevice, sender, device)
+	}
+	return nil
+}
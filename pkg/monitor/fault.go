@@ -0,0 +1,95 @@
+package monitor
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/code-for-venezuela/poweroutage/pkg/events"
+	"github.com/code-for-venezuela/poweroutage/pkg/ups"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SensorFaultEventType is the event type of the sensor fault events.
+const SensorFaultEventType = events.SensorFaultEventType
+
+// SensorFaultEvent is published when the power sensor starts failing and
+// when it recovers.
+type SensorFaultEvent struct {
+	DeviceID string `json:"device_id"`
+	// Status is events.SensorFault or events.SensorRecovered.
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Failures is how many reads failed in a row.
+	Failures  int       `json:"failures"`
+	StartedAt time.Time `json:"started_at"`
+	SentAt    time.Time `json:"sent_at"`
+}
+
+func (m *Monitor) publishSensorFault(status string, now time.Time, err error) {
+	event := SensorFaultEvent{
+		DeviceID:  m.config.MonitorID,
+		Status:    status,
+		Failures:  m.failures,
+		StartedAt: m.faultStart,
+		SentAt:    now,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	payload, err := json.Marshal(event)
+	if err == nil {
+		err = m.publisher.Publish(SensorFaultEventType, payload)
+	}
+	if err != nil {
+		log.Errorf("failed to publish sensor fault event: %v", err)
+	}
+}
+
+// sensorFault backs off reading a failing sensor, up to MaxBackoff between
+// reads, and reinitializes it every ReinitializeAfter failures in a row.
+func (m *Monitor) sensorFault(now time.Time, err error) {
+	m.failures++
+	if m.state != SensorFault {
+		log.Errorf("The power sensor failed: %v", err)
+		m.state = SensorFault
+		m.faultStart = now
+		m.publishSensorFault(events.SensorFault, now, err)
+	}
+	m.gauge("powermonitor.sensorfault", 1)
+
+	backoff := m.config.TickInterval
+	for i := 1; i < m.failures && backoff < m.config.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > m.config.MaxBackoff {
+		backoff = m.config.MaxBackoff
+	}
+	m.nextRead = now.Add(backoff)
+
+	if m.failures%m.config.ReinitializeAfter != 0 {
+		return
+	}
+	sensor, ok := m.sensor.(ups.Reinitializer)
+	if !ok {
+		return
+	}
+	log.Warnf("The power sensor failed %v times in a row, reinitializing it", m.failures)
+	m.metrics.Incr("powermonitor.sensor_reinitialized", m.config.Tags, 1)
+	if err := sensor.Reinitialize(); err != nil {
+		log.Errorf("could not reinitialize the power sensor: %v", err)
+		return
+	}
+	// Try the reinitialized sensor right away.
+	m.nextRead = now
+}
+
+func (m *Monitor) sensorRecovered(now time.Time) {
+	if m.state == SensorFault {
+		log.Infof("The power sensor recovered after %v failures", m.failures)
+		m.publishSensorFault(events.SensorRecovered, now, nil)
+	}
+	m.failures = 0
+	m.nextRead = time.Time{}
+	m.gauge("powermonitor.sensorfault", 0)
+}
@@ -0,0 +1,460 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/code-for-venezuela/poweroutage/pkg/battery"
+	"github.com/code-for-venezuela/poweroutage/pkg/clock"
+	"github.com/code-for-venezuela/poweroutage/pkg/outagedetector"
+	"github.com/code-for-venezuela/poweroutage/pkg/store"
+	"github.com/code-for-venezuela/poweroutage/pkg/telemetry"
+	"github.com/code-for-venezuela/poweroutage/pkg/ups"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// State is the state of the monitor.
+type State int
+
+const (
+	Powered State = iota
+	Outage
+	SensorFault
+)
+
+var stateStrings = [...]string{
+	"powered",
+	"outage",
+	"sensor-fault",
+}
+
+func (s State) String() string {
+	if int(s) < 0 || int(s) >= len(stateStrings) {
+		return "unknown"
+	}
+	return stateStrings[s]
+}
+
+// Config holds the settings of the monitor.
+type Config struct {
+	MonitorID string
+	// Tags are added to every metric.
+	Tags []string
+	// TickInterval is how often the sensor is read.
+	TickInterval time.Duration
+	// ProbeInterval is how often a healthy probe is published. Probes are
+	// also published when the state changes.
+	ProbeInterval time.Duration
+	// LogInterval rate limits the periodic status logs.
+	LogInterval time.Duration
+	Detector    outagedetector.Config
+	// BatteryCapacity_mAh enables the time to empty estimate when set.
+	BatteryCapacity_mAh float64
+	// LastAliveInterval is how often the last alive time is recorded,
+	// every tick when zero.
+	LastAliveInterval time.Duration
+	// BootReason is why the previous run stopped, sent with the restarting
+	// probe.
+	BootReason string
+	// Crashing makes the first probe report a crash loop instead of a
+	// restart.
+	Crashing bool
+	// MaxBackoff caps the time between reads of a failing sensor, 5 minutes
+	// when zero.
+	MaxBackoff time.Duration
+	// ReinitializeAfter is how many reads in a row have to fail before the
+	// sensor is reinitialized, 3 when zero.
+	ReinitializeAfter int
+}
+
+// Dependencies are the collaborators of the monitor.
+type Dependencies struct {
+	Sensor    ups.PowerSensor
+	Estimator *battery.Estimator
+	Recorder  store.OutageRecorder
+	Publisher store.Publisher
+	Metrics   statsd.ClientInterface
+	// LastAlive is optional. When set, an outage found on start is closed if
+	// the device was off for a while, since it only boots once power is back.
+	LastAlive *store.LastAlive
+	// Telemetry is optional, it adds the state of the system to the probes.
+	Telemetry *telemetry.Collector
+	// Clock defaults to the real clock.
+	Clock clock.Clock
+}
+
+// Monitor reads the power sensor, records outages and reports the health of
+// the device.
+type Monitor struct {
+	config    Config
+	sensor    ups.PowerSensor
+	estimator *battery.Estimator
+	recorder  store.OutageRecorder
+	publisher store.Publisher
+	metrics   statsd.ClientInterface
+	lastAlive *store.LastAlive
+	telemetry *telemetry.Collector
+	clock     clock.Clock
+
+	detector *outagedetector.Detector
+	counter  *battery.CoulombCounter
+	counting bool
+
+	state   State
+	reading *reading
+	event   *store.OutageEvent
+	// dirty is set when event has changes that aren't recorded yet.
+	dirty     bool
+	lastLog   time.Time
+	lastProbe time.Time
+	lastBeat  time.Time
+
+	// failures counts the sensor reads failed in a row, the sensor isn't
+	// read again until nextRead.
+	failures   int
+	faultStart time.Time
+	nextRead   time.Time
+
+	// notification is the outage event waiting to be published, it is sent
+	// again at nextNotify while publishing fails.
+	notification *store.OutageEvent
+	nextNotify   time.Time
+	// notifiedEmptyAt is the time to empty estimate last notified, zero
+	// until the first one of the outage.
+	notifiedEmptyAt time.Time
+}
+
+// New creates a monitor. If the recorder has an ongoing incident, e.g. the
+// device restarted during an outage, the monitor starts in the outage state.
+func New(config Config, deps Dependencies) (*Monitor, error) {
+	if deps.Sensor == nil || deps.Estimator == nil || deps.Recorder == nil ||
+		deps.Publisher == nil || deps.Metrics == nil {
+		return nil, fmt.Errorf("the monitor needs a sensor, estimator, recorder, publisher and metrics")
+	}
+	if config.TickInterval <= 0 {
+		return nil, fmt.Errorf("tick interval must be positive, got %v", config.TickInterval)
+	}
+	if deps.Clock == nil {
+		deps.Clock = clock.Real()
+	}
+
+	m := &Monitor{
+		config:    config,
+		sensor:    deps.Sensor,
+		estimator: deps.Estimator,
+		recorder:  deps.Recorder,
+		publisher: deps.Publisher,
+		metrics:   deps.Metrics,
+		lastAlive: deps.LastAlive,
+		telemetry: deps.Telemetry,
+		clock:     deps.Clock,
+		state:     Powered,
+	}
+	if config.BatteryCapacity_mAh > 0 {
+		m.counter = battery.NewCoulombCounter(config.BatteryCapacity_mAh)
+	}
+	if m.config.LastAliveInterval <= 0 {
+		m.config.LastAliveInterval = config.TickInterval
+	}
+	if m.config.MaxBackoff <= 0 {
+		m.config.MaxBackoff = 5 * time.Minute
+	}
+	if m.config.ReinitializeAfter <= 0 {
+		m.config.ReinitializeAfter = 3
+	}
+
+	event, err := deps.Recorder.GetMostRecentEvent()
+	if err == nil {
+		log.Infof("warning, there is already an ongoing event. It started at: %v", event.StartTime)
+		m.event = event
+		m.state = Outage
+		// The start may not have been published before the restart.
+		pending := *event
+		m.notification = &pending
+	}
+	if err != nil && !strings.Contains(err.Error(), "no outage events recorded") {
+		return nil, fmt.Errorf("unexpected error reading most recent event: %v", err)
+	}
+	if m.event != nil && m.lastAlive != nil {
+		if err := m.closeStaleEvent(); err != nil {
+			return nil, err
+		}
+	}
+
+	initial := outagedetector.Powered
+	if m.state == Outage {
+		initial = outagedetector.Outage
+	}
+	m.detector, err = outagedetector.New(config.Detector, initial)
+	if err != nil {
+		return nil, fmt.Errorf("invalid outage detector configuration: %v", err)
+	}
+
+	// Make sure that we log info the first time, after that only one log
+	// entry per LogInterval. This is to not spam the logs.
+	m.lastLog = m.clock.Now().Add(-2 * m.config.LogInterval)
+	return m, nil
+}
+
+// closeStaleEvent finishes the ongoing event when the device was off for
+// longer than a restart takes. The device only boots again once power is
+// back, so the outage ended at some point while it was off.
+func (m *Monitor) closeStaleEvent() error {
+	lastAlive, err := m.lastAlive.Read()
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		log.Warnf("could not read the last alive time: %v", err)
+		return nil
+	}
+	now := m.clock.Now()
+	if now.Sub(lastAlive) < staleAfter*m.config.LastAliveInterval {
+		return nil
+	}
+
+	log.Warnf(
+		"The device lost power at %v during the outage that started at %v. The outage ended between then and now",
+		lastAlive,
+		m.event.StartTime,
+	)
+	m.event.DeviceLostPowerAt = &lastAlive
+	if err := m.recorder.UpdateIncident(*m.event); err != nil {
+		return fmt.Errorf("error recording that the device lost power: %v", err)
+	}
+	if _, err := m.recorder.FinishIncident(now); err != nil {
+		return fmt.Errorf("unexpected error finishing incident: %v", err)
+	}
+	m.event = nil
+	m.notification = nil
+	m.state = Powered
+	return nil
+}
+
+// staleAfter is how many last alive intervals the device has to be off for
+// an ongoing outage to be closed on start.
+const staleAfter = 3
+
+// State returns the current state of the monitor.
+func (m *Monitor) State() State {
+	return m.state
+}
+
+// Event returns the ongoing outage, if any.
+func (m *Monitor) Event() *store.OutageEvent {
+	return m.event
+}
+
+// Run publishes the initial probe and then reads the sensor every tick until
+// ctx is done. Errors are logged and retried on the next tick, the monitor
+// keeps running degraded rather than exiting.
+func (m *Monitor) Run(ctx context.Context) error {
+	m.publishInitialProbe()
+
+	ticker := m.clock.NewTicker(m.config.TickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C():
+			if err := m.Tick(); err != nil {
+				log.Errorf("%v", err)
+			}
+		}
+	}
+}
+
+// reading is the last successful reading of the sensor.
+type reading struct {
+	busVoltage    float32
+	current       float32
+	stateOfCharge float32
+}
+
+// Tick takes a reading and updates the state of the monitor. It returns the
+// errors of the recorder, which are retried on the next tick.
+func (m *Monitor) Tick() error {
+	now := m.clock.Now()
+	if now.Sub(m.lastBeat) >= m.config.LastAliveInterval {
+		m.beat(now)
+	}
+	previous := m.state
+	err := m.tick(now)
+	m.notify(now)
+	m.probe(now, previous)
+	return err
+}
+
+func (m *Monitor) tick(now time.Time) error {
+	if now.Before(m.nextRead) {
+		return nil
+	}
+
+	current, busVoltage, err := m.read()
+	if err != nil {
+		m.sensorFault(now, err)
+		return nil
+	}
+	m.sensorRecovered(now)
+
+	percentage := m.estimator.StateOfCharge(busVoltage, current)
+	m.gauge("powermonitor.batterylevel", float64(percentage))
+	m.reading = &reading{busVoltage: busVoltage, current: current, stateOfCharge: percentage}
+
+	detected, changed := m.detector.Update(outagedetector.Sample{
+		Time:         now,
+		Current_mA:   current,
+		BusVoltage_V: busVoltage,
+	})
+	if changed {
+		log.Infof("Power state changed to %v (current: %.1fmA, bus voltage: %.2fV)", detected, current, busVoltage)
+	}
+
+	if detected == outagedetector.Outage {
+		m.state = Outage
+		return m.onOutage(now, current, percentage)
+	}
+	m.state = Powered
+	return m.onPowered(now, percentage)
+}
+
+func (m *Monitor) read() (float32, float32, error) {
+	current, err := m.sensor.GetCurrent_mA()
+	if err != nil {
+		return 0, 0, fmt.Errorf("error reading current: %v", err)
+	}
+	busVoltage, err := m.sensor.GetBusVoltage_V()
+	if err != nil {
+		return 0, 0, fmt.Errorf("error reading bus voltage: %v", err)
+	}
+	return current, busVoltage, nil
+}
+
+func (m *Monitor) onOutage(now time.Time, current, percentage float32) error {
+	m.gauge("powermonitor.outage", 0)
+	started := false
+	if m.event == nil {
+		log.Infof("There is no ongoing incident. Starting a new one.")
+		event, err := m.recorder.StartIncident(now)
+		if err != nil {
+			m.metrics.Incr("powermonitor.recordererror", m.config.Tags, 1)
+			return fmt.Errorf("error starting new event: %v", err)
+		}
+		m.event = event
+		m.notifiedEmptyAt = time.Time{}
+		started = true
+	}
+
+	var timeToEmpty time.Duration
+	estimated := false
+	if m.counter != nil {
+		if !m.counting {
+			m.counter.Reset(now, percentage)
+			m.counting = true
+		}
+		m.counter.Add(now, current)
+		timeToEmpty, estimated = m.counter.TimeToEmpty()
+	}
+	if estimated {
+		m.gauge("powermonitor.time_to_empty", timeToEmpty.Seconds())
+		emptyAt := now.Add(timeToEmpty)
+		first := m.event.EstimatedEmptyAt == nil
+		m.event.EstimatedEmptyAt = &emptyAt
+		m.dirty = true
+		// Record the first estimate right away, then once per LogInterval.
+		if first {
+			m.persist()
+		}
+		m.notifyEstimate(now, emptyAt)
+	}
+	// The start goes with the first estimate when there is one already.
+	if started && m.notifiedEmptyAt.IsZero() {
+		m.queueNotification(now, *m.event)
+	}
+
+	if now.Sub(m.lastLog) < m.config.LogInterval {
+		return nil
+	}
+	m.lastLog = now
+	if !estimated {
+		log.Infof(
+			"Power is not available. This is the remaining battery: %.1f%%, current: %.1f",
+			percentage,
+			current,
+		)
+		return nil
+	}
+	log.Infof(
+		"Power is not available. This is the remaining battery: %.1f%%, current: %.1f, time to empty: %v",
+		percentage,
+		current,
+		timeToEmpty.Round(time.Minute),
+	)
+	m.persist()
+	return nil
+}
+
+// Persist writes the ongoing incident, with the latest estimates, to the
+// recorder, and the last alive time. It is called on shutdown, when the
+// monitor is no longer running.
+func (m *Monitor) Persist() error {
+	m.beat(m.clock.Now())
+	if m.event == nil || !m.dirty {
+		return nil
+	}
+	if err := m.recorder.UpdateIncident(*m.event); err != nil {
+		return err
+	}
+	m.dirty = false
+	return nil
+}
+
+func (m *Monitor) persist() {
+	if err := m.Persist(); err != nil {
+		log.Warnf("could not record the time to empty estimate: %v", err)
+	}
+}
+
+func (m *Monitor) beat(now time.Time) {
+	if m.lastAlive == nil {
+		return
+	}
+	if err := m.lastAlive.Write(now); err != nil {
+		log.Warnf("could not record the last alive time: %v", err)
+		return
+	}
+	m.lastBeat = now
+}
+
+func (m *Monitor) onPowered(now time.Time, percentage float32) error {
+	m.counting = false
+	if now.Sub(m.lastLog) >= m.config.LogInterval {
+		log.Infof("Power is available. This is the remaining battery: %.1f%%", percentage)
+		m.lastLog = now
+	}
+	m.gauge("powermonitor.outage", 1)
+
+	if m.event != nil {
+		log.Infof("Power outage ended. Recording event")
+		if _, err := m.recorder.FinishIncident(now); err != nil {
+			m.metrics.Incr("powermonitor.recordererror", m.config.Tags, 1)
+			return fmt.Errorf("unexpected error finishing incident: %v", err)
+		}
+		m.event = nil
+		m.dirty = false
+		// The event syncer publishes the finished event, a start that
+		// wasn't published yet is part of it.
+		m.notification = nil
+	}
+	return nil
+}
+
+func (m *Monitor) gauge(name string, value float64) {
+	m.metrics.Gauge(name, value, m.config.Tags, 1)
+}
@@ -0,0 +1,762 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/code-for-venezuela/poweroutage/pkg/battery"
+	"github.com/code-for-venezuela/poweroutage/pkg/clock"
+	"github.com/code-for-venezuela/poweroutage/pkg/eventsreader"
+	"github.com/code-for-venezuela/poweroutage/pkg/outagedetector"
+	"github.com/code-for-venezuela/poweroutage/pkg/store"
+	"github.com/code-for-venezuela/poweroutage/pkg/telemetry"
+	"github.com/code-for-venezuela/poweroutage/pkg/ups"
+	"github.com/code-for-venezuela/poweroutage/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type published struct {
+	eventType string
+	payload   []byte
+}
+
+type fakePublisher struct {
+	mu     sync.Mutex
+	events []published
+	err    error
+}
+
+func (p *fakePublisher) Publish(eventType string, payload []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.events = append(p.events, published{eventType, payload})
+	return nil
+}
+
+func (p *fakePublisher) PublishOutageEvent(event store.OutageEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return p.Publish("power_outage_incident", payload)
+}
+
+func (p *fakePublisher) Close() error {
+	return nil
+}
+
+func (p *fakePublisher) sensorFaults(t *testing.T) []SensorFaultEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var faults []SensorFaultEvent
+	for _, e := range p.events {
+		if e.eventType != SensorFaultEventType {
+			continue
+		}
+		var fault SensorFaultEvent
+		require.NoError(t, json.Unmarshal(e.payload, &fault))
+		faults = append(faults, fault)
+	}
+	return faults
+}
+
+func (p *fakePublisher) probes(t *testing.T) []eventsreader.Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var probes []eventsreader.Event
+	for _, e := range p.events {
+		if e.eventType != ProbeEventType {
+			continue
+		}
+		var probe eventsreader.Event
+		require.NoError(t, json.Unmarshal(e.payload, &probe))
+		probes = append(probes, probe)
+	}
+	return probes
+}
+
+func (p *fakePublisher) outageEvents(t *testing.T) []store.OutageEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var events []store.OutageEvent
+	for _, e := range p.events {
+		if e.eventType != "power_outage_incident" {
+			continue
+		}
+		var event store.OutageEvent
+		require.NoError(t, json.Unmarshal(e.payload, &event))
+		events = append(events, event)
+	}
+	return events
+}
+
+func (p *fakePublisher) setErr(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
+
+type fakeMetrics struct {
+	util.DummyStatsdClient
+	mu     sync.Mutex
+	gauges map[string]float64
+	counts map[string]int
+}
+
+func (m *fakeMetrics) Incr(name string, tags []string, rate float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counts == nil {
+		m.counts = map[string]int{}
+	}
+	m.counts[name]++
+	return nil
+}
+
+func (m *fakeMetrics) count(name string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counts[name]
+}
+
+func (m *fakeMetrics) Gauge(name string, value float64, tags []string, rate float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.gauges == nil {
+		m.gauges = map[string]float64{}
+	}
+	m.gauges[name] = value
+	return nil
+}
+
+func (m *fakeMetrics) gauge(name string) (float64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, ok := m.gauges[name]
+	return value, ok
+}
+
+type fixture struct {
+	monitor   *Monitor
+	sensor    *ups.SimulatedSensor
+	recorder  store.OutageRecorder
+	publisher *fakePublisher
+	metrics   *fakeMetrics
+	lastAlive *store.LastAlive
+	clock     *clock.Fake
+}
+
+var (
+	powered = ups.NewSample(4.1, 500, 0)
+	outage  = ups.NewSample(3.9, -800, 0)
+)
+
+func newFixture(t *testing.T, config Config) *fixture {
+	dir := t.TempDir()
+	recorder, err := store.NewFileSystemRecorder("test-monitor", filepath.Join(dir, "events"), filepath.Join(dir, "finished"))
+	require.NoError(t, err)
+	return newFixtureWithRecorder(t, config, recorder, dir)
+}
+
+// newFixtureWithRecorder creates a monitor that keeps its files in dir, as it
+// would when the device boots.
+func newFixtureWithRecorder(t *testing.T, config Config, recorder store.OutageRecorder, dir string) *fixture {
+	finished := filepath.Join(dir, "finished")
+	require.NoError(t, os.MkdirAll(finished, 0755))
+	estimator, err := battery.NewEstimator(battery.LiIon, 1, 0)
+	require.NoError(t, err)
+	f := &fixture{
+		sensor:    ups.NewSimulatedSensor(false, powered),
+		recorder:  recorder,
+		publisher: &fakePublisher{},
+		metrics:   &fakeMetrics{},
+		lastAlive: store.NewLastAlive(filepath.Join(dir, "last_alive")),
+		clock:     clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+	}
+	f.monitor, err = New(config, Dependencies{
+		Sensor:    f.sensor,
+		Estimator: estimator,
+		Recorder:  f.recorder,
+		Publisher: f.publisher,
+		Metrics:   f.metrics,
+		LastAlive: f.lastAlive,
+		Clock:     f.clock,
+	})
+	require.NoError(t, err)
+	return f
+}
+
+// tick advances the clock by one interval with the sensor reading sample.
+func (f *fixture) tick(t *testing.T, sample ups.Sample) {
+	f.sensor.Set(sample)
+	f.clock.Advance(f.monitor.config.TickInterval)
+	require.NoError(t, f.monitor.Tick())
+}
+
+func (f *fixture) finishedEvents(t *testing.T) []store.OutageEvent {
+	_, events, err := f.recorder.GetFinishedEvents()
+	require.NoError(t, err)
+	return events
+}
+
+func testConfig() Config {
+	return Config{
+		MonitorID:     "test-monitor",
+		TickInterval:  7 * time.Second,
+		ProbeInterval: 4 * time.Hour,
+		LogInterval:   time.Hour,
+		Detector: outagedetector.Config{
+			EnterThreshold_mA: -10,
+			ExitThreshold_mA:  0,
+			Samples:           3,
+		},
+		BatteryCapacity_mAh: 2000,
+	}
+}
+
+func TestMonitorRecordsOutage(t *testing.T) {
+	f := newFixture(t, testConfig())
+
+	f.tick(t, powered)
+	assert.Equal(t, Powered, f.monitor.State())
+	level, ok := f.metrics.gauge("powermonitor.batterylevel")
+	require.True(t, ok)
+	assert.InDelta(t, 93.3, level, 0.1)
+
+	// A single bad sample is not an outage.
+	f.tick(t, outage)
+	f.tick(t, powered)
+	assert.Equal(t, Powered, f.monitor.State())
+	assert.Nil(t, f.monitor.Event())
+
+	for i := 0; i < 3; i++ {
+		f.tick(t, outage)
+	}
+	startedAt := f.clock.Now()
+	assert.Equal(t, Outage, f.monitor.State())
+	require.NotNil(t, f.monitor.Event())
+	assert.Equal(t, store.Ongoing, f.monitor.Event().Status)
+	assert.True(t, startedAt.Equal(f.monitor.Event().StartTime))
+	gauge, _ := f.metrics.gauge("powermonitor.outage")
+	assert.Equal(t, float64(0), gauge)
+
+	// The outage lasts an hour.
+	for i := 0; i < 500; i++ {
+		f.tick(t, outage)
+	}
+	tte, ok := f.metrics.gauge("powermonitor.time_to_empty")
+	require.True(t, ok)
+	assert.Greater(t, tte, float64(0))
+	ongoing, err := f.recorder.GetMostRecentEvent()
+	require.NoError(t, err)
+	require.NotNil(t, ongoing.EstimatedEmptyAt)
+
+	for i := 0; i < 3; i++ {
+		f.tick(t, powered)
+	}
+	endedAt := f.clock.Now()
+	assert.Equal(t, Powered, f.monitor.State())
+	assert.Nil(t, f.monitor.Event())
+	gauge, _ = f.metrics.gauge("powermonitor.outage")
+	assert.Equal(t, float64(1), gauge)
+
+	events := f.finishedEvents(t)
+	require.Len(t, events, 1)
+	assert.Equal(t, store.Resolved, events[0].Status)
+	assert.Equal(t, "test-monitor", events[0].DeviceId)
+	// The times come from the clock of the monitor.
+	assert.True(t, startedAt.Equal(events[0].StartTime))
+	assert.True(t, endedAt.Equal(events[0].EndTime))
+}
+
+func TestMonitorResumesOngoingOutage(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := store.NewFileSystemRecorder("test-monitor", filepath.Join(dir, "events"), filepath.Join(dir, "finished"))
+	require.NoError(t, err)
+	event, err := recorder.StartIncident(time.Date(2023, 12, 31, 23, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+
+	f := newFixtureWithRecorder(t, testConfig(), recorder, dir)
+	assert.Equal(t, Outage, f.monitor.State())
+	require.NotNil(t, f.monitor.Event())
+	assert.Equal(t, event.ID, f.monitor.Event().ID)
+
+	for i := 0; i < 3; i++ {
+		f.tick(t, powered)
+	}
+	events := f.finishedEvents(t)
+	require.Len(t, events, 1)
+	assert.Equal(t, event.ID, events[0].ID)
+
+	// The start is notified again, in case it wasn't before the restart.
+	notified := f.publisher.outageEvents(t)
+	require.Len(t, notified, 1)
+	assert.Equal(t, store.Ongoing, notified[0].Status)
+}
+
+func TestMonitorNotifiesOutages(t *testing.T) {
+	f := newFixture(t, testConfig())
+
+	for i := 0; i < 3; i++ {
+		f.tick(t, outage)
+	}
+	require.Equal(t, Outage, f.monitor.State())
+	notified := f.publisher.outageEvents(t)
+	require.Len(t, notified, 1)
+	assert.Equal(t, f.monitor.Event().ID, notified[0].ID)
+	assert.Equal(t, store.Ongoing, notified[0].Status)
+	// The first estimate goes with the start.
+	assert.NotNil(t, notified[0].EstimatedEmptyAt)
+
+	// The estimate barely moves, it isn't published again.
+	for i := 0; i < 100; i++ {
+		f.tick(t, outage)
+	}
+	assert.Len(t, f.publisher.outageEvents(t), 1)
+
+	// The end is published once, by the event syncer from the finished
+	// event.
+	for i := 0; i < 3; i++ {
+		f.tick(t, powered)
+	}
+	assert.Len(t, f.publisher.outageEvents(t), 1)
+	finished := f.finishedEvents(t)
+	require.Len(t, finished, 1)
+	assert.Equal(t, notified[0].ID, finished[0].ID)
+	assert.Equal(t, store.Resolved, finished[0].Status)
+}
+
+func TestMonitorNotifiesEstimateChanges(t *testing.T) {
+	f := newFixture(t, testConfig())
+	for i := 0; i < 3; i++ {
+		f.tick(t, outage)
+	}
+	notified := f.publisher.outageEvents(t)
+	require.Len(t, notified, 1)
+	require.NotNil(t, notified[0].EstimatedEmptyAt)
+
+	// Twice the load, the battery runs out much sooner.
+	for i := 0; i < 10; i++ {
+		f.tick(t, ups.NewSample(3.9, -1600, 0))
+	}
+	notified = f.publisher.outageEvents(t)
+	require.Greater(t, len(notified), 1)
+	assert.Less(t, len(notified), 10, "only big changes are published")
+	for i := 1; i < len(notified); i++ {
+		assert.Equal(t, notified[0].ID, notified[i].ID)
+		assert.Equal(t, store.Ongoing, notified[i].Status)
+		require.NotNil(t, notified[i].EstimatedEmptyAt)
+		earlier := notified[i-1].EstimatedEmptyAt.Add(-estimateChangeToNotify)
+		assert.False(t, notified[i].EstimatedEmptyAt.After(earlier))
+	}
+}
+
+func TestMonitorQueuesStartNotificationWhileOffline(t *testing.T) {
+	f := newFixture(t, testConfig())
+	f.publisher.setErr(errors.New("network is unreachable"))
+
+	for i := 0; i < 3; i++ {
+		f.tick(t, outage)
+	}
+	require.Equal(t, Outage, f.monitor.State())
+	assert.Empty(t, f.publisher.outageEvents(t))
+
+	f.publisher.setErr(nil)
+	// Retried once the retry interval went by, and only once.
+	for i := 0; i < 30; i++ {
+		f.tick(t, outage)
+	}
+	notified := f.publisher.outageEvents(t)
+	require.Len(t, notified, 1)
+	assert.Equal(t, f.monitor.Event().ID, notified[0].ID)
+	assert.Equal(t, store.Ongoing, notified[0].Status)
+}
+
+func TestMonitorDoesNotRetryStartAlreadyPublished(t *testing.T) {
+	f := newFixture(t, testConfig())
+	f.publisher.setErr(store.ErrAlreadyPublished)
+	for i := 0; i < 3; i++ {
+		f.tick(t, outage)
+	}
+	f.publisher.setErr(nil)
+	for i := 0; i < 30; i++ {
+		f.tick(t, outage)
+	}
+	assert.Empty(t, f.publisher.outageEvents(t))
+}
+
+func TestMonitorDropsStartNotificationOnceFinished(t *testing.T) {
+	f := newFixture(t, testConfig())
+	f.publisher.setErr(errors.New("network is unreachable"))
+	for i := 0; i < 3; i++ {
+		f.tick(t, outage)
+	}
+	for i := 0; i < 3; i++ {
+		f.tick(t, powered)
+	}
+	f.publisher.setErr(nil)
+	for i := 0; i < 30; i++ {
+		f.tick(t, powered)
+	}
+	assert.Empty(t, f.publisher.outageEvents(t))
+	// The finished event is there for the syncer to publish.
+	assert.Len(t, f.finishedEvents(t), 1)
+}
+
+func TestMonitorSensorFault(t *testing.T) {
+	f := newFixture(t, testConfig())
+
+	f.tick(t, powered)
+	f.tick(t, ups.Sample{Err: errors.New("remote I/O error")})
+	assert.Equal(t, SensorFault, f.monitor.State())
+	fault, _ := f.metrics.gauge("powermonitor.sensorfault")
+	assert.Equal(t, float64(1), fault)
+
+	f.tick(t, powered)
+	assert.Equal(t, Powered, f.monitor.State())
+	fault, _ = f.metrics.gauge("powermonitor.sensorfault")
+	assert.Equal(t, float64(0), fault)
+}
+
+func TestMonitorProbes(t *testing.T) {
+	config := testConfig()
+	config.BootReason = "battery-drained"
+	f := newFixture(t, config)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- f.monitor.Run(ctx) }()
+
+	require.Eventually(t, func() bool { return len(f.publisher.probes(t)) == 1 }, time.Second, time.Millisecond)
+	probes := f.publisher.probes(t)
+	assert.Equal(t, "restarting", probes[0].Status)
+	assert.Equal(t, "battery-drained", probes[0].Reason)
+	assert.Equal(t, "test-monitor", probes[0].DeviceID)
+
+	// The next probe is published on the first tick after the interval.
+	f.clock.Advance(config.ProbeInterval - config.TickInterval)
+	require.Eventually(t, func() bool {
+		f.clock.Advance(config.TickInterval)
+		return len(f.publisher.probes(t)) == 2
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "healthy", f.publisher.probes(t)[1].Status)
+	assert.Empty(t, f.publisher.probes(t)[1].Reason)
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return after the context was cancelled")
+	}
+}
+
+func TestMonitorFailedProbeIsRetried(t *testing.T) {
+	f := newFixture(t, testConfig())
+	f.publisher.err = errors.New("network is unreachable")
+	f.monitor.publishInitialProbe()
+	assert.Empty(t, f.publisher.probes(t))
+
+	f.clock.Advance(4 * time.Hour)
+	require.NoError(t, f.monitor.Tick())
+	assert.Empty(t, f.publisher.probes(t))
+
+	f.publisher.err = nil
+	f.tick(t, powered)
+	require.Len(t, f.publisher.probes(t), 1)
+	assert.Equal(t, "healthy", f.publisher.probes(t)[0].Status)
+
+	f.tick(t, powered)
+	assert.Len(t, f.publisher.probes(t), 1)
+}
+
+func TestMonitorPersistsEstimateOnShutdown(t *testing.T) {
+	f := newFixture(t, testConfig())
+	for i := 0; i < 4; i++ {
+		f.tick(t, outage)
+	}
+	recorded, err := f.recorder.GetMostRecentEvent()
+	require.NoError(t, err)
+	require.NotNil(t, recorded.EstimatedEmptyAt)
+
+	// The estimate keeps moving but is only recorded once per LogInterval.
+	for i := 0; i < 10; i++ {
+		f.tick(t, ups.NewSample(3.8, -1500, 0))
+	}
+	stale, err := f.recorder.GetMostRecentEvent()
+	require.NoError(t, err)
+	assert.True(t, stale.EstimatedEmptyAt.Equal(*recorded.EstimatedEmptyAt))
+
+	require.NoError(t, f.monitor.Persist())
+	persisted, err := f.recorder.GetMostRecentEvent()
+	require.NoError(t, err)
+	assert.True(t, persisted.EstimatedEmptyAt.Equal(*f.monitor.Event().EstimatedEmptyAt))
+	assert.True(t, persisted.EstimatedEmptyAt.Before(*recorded.EstimatedEmptyAt))
+}
+
+func TestMonitorClosesOutageThatOutlastedTheBattery(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		offFor  time.Duration
+		resumed bool
+	}{
+		{name: "restart", offFor: 10 * time.Second, resumed: true},
+		{name: "battery ran out", offFor: 6 * time.Hour},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			recorder, err := store.NewFileSystemRecorder("test-monitor", filepath.Join(dir, "events"), filepath.Join(dir, "finished"))
+			require.NoError(t, err)
+			start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			lostPowerAt := start.Add(-tc.offFor)
+			event, err := recorder.StartIncident(lostPowerAt.Add(-time.Hour))
+			require.NoError(t, err)
+			require.NoError(t, store.NewLastAlive(filepath.Join(dir, "last_alive")).Write(lostPowerAt))
+
+			config := testConfig()
+			config.LastAliveInterval = time.Minute
+			f := newFixtureWithRecorder(t, config, recorder, dir)
+			if tc.resumed {
+				assert.Equal(t, Outage, f.monitor.State())
+				assert.Empty(t, f.finishedEvents(t))
+				return
+			}
+
+			assert.Equal(t, Powered, f.monitor.State())
+			assert.Nil(t, f.monitor.Event())
+			events := f.finishedEvents(t)
+			require.Len(t, events, 1)
+			assert.Equal(t, event.ID, events[0].ID)
+			assert.Equal(t, store.Resolved, events[0].Status)
+			require.NotNil(t, events[0].DeviceLostPowerAt)
+			assert.True(t, lostPowerAt.Equal(*events[0].DeviceLostPowerAt))
+			// It ended by the time the device booted again.
+			assert.True(t, start.Equal(events[0].EndTime))
+		})
+	}
+}
+
+func TestMonitorRecordsLastAlive(t *testing.T) {
+	config := testConfig()
+	config.LastAliveInterval = time.Minute
+	f := newFixture(t, config)
+
+	f.tick(t, powered)
+	first, err := f.lastAlive.Read()
+	require.NoError(t, err)
+	assert.True(t, f.clock.Now().Equal(first))
+
+	// Only once per interval, even while the sensor fails.
+	f.tick(t, ups.Sample{Err: errors.New("remote I/O error")})
+	last, err := f.lastAlive.Read()
+	require.NoError(t, err)
+	assert.True(t, first.Equal(last))
+	for i := 0; i < 8; i++ {
+		f.tick(t, ups.Sample{Err: errors.New("remote I/O error")})
+	}
+	last, err = f.lastAlive.Read()
+	require.NoError(t, err)
+	assert.True(t, last.After(first))
+
+	f.clock.Advance(time.Second)
+	require.NoError(t, f.monitor.Persist())
+	last, err = f.lastAlive.Read()
+	require.NoError(t, err)
+	assert.True(t, f.clock.Now().Equal(last))
+}
+
+// brokenSensor fails until it is reinitialized fixAfter times.
+type brokenSensor struct {
+	*ups.SimulatedSensor
+	reads    int
+	reinits  int
+	fixAfter int
+}
+
+func (s *brokenSensor) GetCurrent_mA() (float32, error) {
+	s.reads++
+	if s.reinits < s.fixAfter {
+		return 0, errors.New("remote I/O error")
+	}
+	return s.SimulatedSensor.GetCurrent_mA()
+}
+
+func (s *brokenSensor) Reinitialize() error {
+	s.reinits++
+	if s.reinits < s.fixAfter {
+		return errors.New("no device at 0x43")
+	}
+	return nil
+}
+
+func TestMonitorSensorTransientFault(t *testing.T) {
+	f := newFixture(t, testConfig())
+
+	f.tick(t, powered)
+	f.tick(t, ups.Sample{Err: errors.New("remote I/O error")})
+	f.tick(t, ups.Sample{Err: errors.New("remote I/O error")})
+	assert.Equal(t, SensorFault, f.monitor.State())
+
+	// The second failure doubled the wait before the next read.
+	f.tick(t, powered)
+	assert.Equal(t, SensorFault, f.monitor.State())
+	f.tick(t, powered)
+	assert.Equal(t, Powered, f.monitor.State())
+
+	faults := f.publisher.sensorFaults(t)
+	require.Len(t, faults, 2)
+	assert.Equal(t, "fault", faults[0].Status)
+	assert.Contains(t, faults[0].Error, "remote I/O error")
+	assert.Equal(t, "recovered", faults[1].Status)
+	assert.Equal(t, 2, faults[1].Failures)
+	assert.True(t, faults[0].StartedAt.Equal(faults[1].StartedAt))
+}
+
+func TestMonitorSensorPermanentFault(t *testing.T) {
+	config := testConfig()
+	config.MaxBackoff = time.Minute
+	f := newFixture(t, config)
+	sensor := &brokenSensor{SimulatedSensor: f.sensor, fixAfter: 3}
+	f.monitor.sensor = sensor
+
+	// An hour of ticks.
+	for i := 0; i < 514; i++ {
+		f.tick(t, powered)
+	}
+	assert.Equal(t, Powered, f.monitor.State())
+	// Reads back off to once a minute, and the sensor is fixed by the third
+	// reinitialization, after 9 failures.
+	assert.Equal(t, 3, sensor.reinits)
+	assert.Equal(t, 3, f.metrics.count("powermonitor.sensor_reinitialized"))
+	faults := f.publisher.sensorFaults(t)
+	require.Len(t, faults, 2)
+	assert.Equal(t, 9, faults[1].Failures)
+}
+
+func TestMonitorSensorNeverRecovers(t *testing.T) {
+	config := testConfig()
+	config.MaxBackoff = time.Minute
+	f := newFixture(t, config)
+	sensor := &brokenSensor{SimulatedSensor: f.sensor, fixAfter: 1000}
+	f.monitor.sensor = sensor
+
+	for i := 0; i < 514; i++ {
+		f.tick(t, powered)
+	}
+	assert.Equal(t, SensorFault, f.monitor.State())
+	// 7s, 14s, 28s, 56s and then once a minute, rounded up to the next tick.
+	assert.Equal(t, 60, sensor.reads)
+	assert.Equal(t, sensor.reads/3, sensor.reinits)
+	assert.Len(t, f.publisher.sensorFaults(t), 1)
+}
+
+// failingRecorder fails to start and finish incidents while err is set.
+type failingRecorder struct {
+	store.OutageRecorder
+	err error
+}
+
+func (r *failingRecorder) StartIncident(at time.Time) (*store.OutageEvent, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.OutageRecorder.StartIncident(at)
+}
+
+func (r *failingRecorder) FinishIncident(at time.Time) (*store.OutageEvent, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.OutageRecorder.FinishIncident(at)
+}
+
+func TestMonitorRetriesRecorderErrors(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := store.NewFileSystemRecorder("test-monitor", filepath.Join(dir, "events"), filepath.Join(dir, "finished"))
+	require.NoError(t, err)
+	recorder := &failingRecorder{OutageRecorder: fs, err: errors.New("read-only file system")}
+	f := newFixtureWithRecorder(t, testConfig(), recorder, dir)
+
+	for i := 0; i < 2; i++ {
+		f.tick(t, outage)
+	}
+	f.sensor.Set(outage)
+	f.clock.Advance(f.monitor.config.TickInterval)
+	assert.Error(t, f.monitor.Tick())
+	assert.Equal(t, Outage, f.monitor.State())
+	assert.Nil(t, f.monitor.Event())
+	assert.Equal(t, 1, f.metrics.count("powermonitor.recordererror"))
+
+	recorder.err = nil
+	f.tick(t, outage)
+	require.NotNil(t, f.monitor.Event())
+
+	recorder.err = errors.New("read-only file system")
+	for i := 0; i < 2; i++ {
+		f.tick(t, powered)
+	}
+	f.sensor.Set(powered)
+	f.clock.Advance(f.monitor.config.TickInterval)
+	assert.Error(t, f.monitor.Tick())
+	assert.NotNil(t, f.monitor.Event(), "the incident is finished on the next tick")
+
+	recorder.err = nil
+	f.tick(t, powered)
+	assert.Nil(t, f.monitor.Event())
+	assert.Len(t, f.finishedEvents(t), 1)
+}
+
+func TestMonitorProbesStateChanges(t *testing.T) {
+	f := newFixture(t, testConfig())
+	f.monitor.telemetry = telemetry.NewCollector("0123456789ab", t.TempDir(), f.clock.Now(), func() (int, error) {
+		names, _, err := f.recorder.GetFinishedEvents()
+		return len(names), err
+	})
+
+	f.monitor.publishInitialProbe()
+	f.tick(t, powered)
+	require.Len(t, f.publisher.probes(t), 1)
+	for i := 0; i < 3; i++ {
+		f.tick(t, outage)
+	}
+	for i := 0; i < 3; i++ {
+		f.tick(t, powered)
+	}
+	f.tick(t, ups.Sample{Err: errors.New("remote I/O error")})
+
+	probes := f.publisher.probes(t)[1:]
+	require.Len(t, probes, 3)
+	for i, state := range []string{"outage", "powered", "sensor-fault"} {
+		assert.Equal(t, "state-change", probes[i].Status)
+		assert.Equal(t, state, probes[i].State)
+		require.NotNil(t, probes[i].Telemetry)
+		assert.Equal(t, "0123456789ab", probes[i].Telemetry.Version)
+	}
+
+	outageProbe := probes[0].Telemetry
+	require.NotNil(t, outageProbe.Current_mA)
+	assert.Equal(t, float32(-800), *outageProbe.Current_mA)
+	require.NotNil(t, outageProbe.BatteryVoltage_V)
+	assert.Equal(t, float32(3.9), *outageProbe.BatteryVoltage_V)
+	require.NotNil(t, outageProbe.Power_W)
+	assert.InDelta(t, 3.12, *outageProbe.Power_W, 0.01)
+	require.NotNil(t, outageProbe.StateOfCharge)
+	assert.Equal(t, int64(28), outageProbe.Uptime_s)
+	require.NotNil(t, outageProbe.PendingSync)
+	assert.Equal(t, 0, *outageProbe.PendingSync)
+	require.NotNil(t, probes[1].Telemetry.PendingSync)
+	assert.Equal(t, 1, *probes[1].Telemetry.PendingSync, "the outage waits to be synced")
+
+	// Without a working sensor there are no readings to send.
+	assert.Nil(t, probes[2].Telemetry.Current_mA)
+}
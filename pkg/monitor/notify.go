@@ -0,0 +1,59 @@
+package monitor
+
+import (
+	"errors"
+	"time"
+
+	"github.com/code-for-venezuela/poweroutage/pkg/store"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// notifyRetryInterval is how often a start notification that failed, e.g.
+// while the device was offline, is sent again.
+const notifyRetryInterval = time.Minute
+
+// estimateChangeToNotify is how much the time to empty estimate has to move
+// for the ongoing outage to be published again.
+const estimateChangeToNotify = 15 * time.Minute
+
+// notifyEstimate publishes the ongoing outage with its first time to empty
+// estimate, and again when the estimate moves by estimateChangeToNotify, so
+// the backend knows when the device is about to go dark.
+func (m *Monitor) notifyEstimate(now, emptyAt time.Time) {
+	if !m.notifiedEmptyAt.IsZero() {
+		change := emptyAt.Sub(m.notifiedEmptyAt)
+		if change < estimateChangeToNotify && change > -estimateChangeToNotify {
+			return
+		}
+	}
+	m.notifiedEmptyAt = emptyAt
+	m.queueNotification(now, *m.event)
+}
+
+// queueNotification publishes the ongoing event as soon as possible,
+// replacing the notification waiting to be sent, if any. Outage events are
+// upserted by ID, so sending one more than once doesn't duplicate it. Finished
+// events are left to the event syncer.
+func (m *Monitor) queueNotification(now time.Time, event store.OutageEvent) {
+	m.notification = &event
+	m.nextNotify = now
+	m.notify(now)
+}
+
+// notify sends the queued notification, retrying it every
+// notifyRetryInterval while it fails.
+func (m *Monitor) notify(now time.Time) {
+	if m.notification == nil || now.Before(m.nextNotify) {
+		return
+	}
+	event := *m.notification
+	err := m.publisher.PublishOutageEvent(event)
+	if err != nil && !errors.Is(err, store.ErrAlreadyPublished) {
+		m.nextNotify = now.Add(notifyRetryInterval)
+		log.Warnf("could not notify that outage %v started, will retry in %v: %v", event.ID, notifyRetryInterval, err)
+		return
+	}
+	log.Infof("Notified that outage %v is %v", event.ID, event.Status)
+	m.notification = nil
+}
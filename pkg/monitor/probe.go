@@ -0,0 +1,98 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/code-for-venezuela/poweroutage/pkg/events"
+	"github.com/code-for-venezuela/poweroutage/pkg/eventsreader"
+	"github.com/code-for-venezuela/poweroutage/pkg/store"
+	"github.com/code-for-venezuela/poweroutage/pkg/telemetry"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ProbeEventType is the event type of the keep alive probes.
+const ProbeEventType = events.ProbeEventType
+
+// PublishProbe publishes a keep alive probe.
+func PublishProbe(publisher store.Publisher, probe eventsreader.Event) error {
+	jsonData, err := json.Marshal(probe)
+	if err != nil {
+		return fmt.Errorf("failed to serialize probe: %v", err)
+	}
+	if err := publisher.Publish(ProbeEventType, jsonData); err != nil {
+		return err
+	}
+	return nil
+}
+
+// publishProbe publishes a probe with the given status and the telemetry of
+// the device. reason is only set on the restarting probe.
+func (m *Monitor) publishProbe(status, reason string) error {
+	now := m.clock.Now()
+	probe := eventsreader.Event{
+		DeviceID:  m.config.MonitorID,
+		SentAt:    now,
+		Status:    status,
+		Reason:    reason,
+		State:     m.state.String(),
+		Telemetry: m.snapshot(),
+	}
+	err := PublishProbe(m.publisher, probe)
+	if err != nil {
+		log.Errorf("failed to publish probe event to angostura: %v", err)
+		return err
+	}
+	log.Infof("successfully published probe event to angostura")
+	return nil
+}
+
+// snapshot returns the telemetry of the device, with the last battery
+// readings unless the sensor is failing.
+func (m *Monitor) snapshot() *telemetry.Snapshot {
+	var snapshot telemetry.Snapshot
+	if m.telemetry != nil {
+		snapshot = m.telemetry.Collect(m.clock.Now())
+	}
+	if m.reading == nil || m.state == SensorFault {
+		return &snapshot
+	}
+	reading := *m.reading
+	snapshot.BatteryVoltage_V = &reading.busVoltage
+	snapshot.Current_mA = &reading.current
+	snapshot.StateOfCharge = &reading.stateOfCharge
+	if power, err := m.sensor.GetPower_W(); err == nil {
+		snapshot.Power_W = &power
+	}
+	return &snapshot
+}
+
+func (m *Monitor) publishInitialProbe() {
+	// Even when it fails, wait a whole interval before the next probe.
+	m.lastProbe = m.clock.Now()
+	status := "restarting"
+	if m.config.Crashing {
+		status = "crashing"
+	}
+	if err := m.publishProbe(status, m.config.BootReason); err != nil {
+		log.Errorf("failed to published to angostura on start")
+	}
+}
+
+// probe publishes a probe when the state of the monitor changed, or every
+// ProbeInterval otherwise.
+func (m *Monitor) probe(now time.Time, previous State) {
+	if m.state != previous {
+		if m.publishProbe("state-change", "") == nil {
+			m.lastProbe = now
+		}
+		return
+	}
+	if now.Sub(m.lastProbe) >= m.config.ProbeInterval {
+		if m.publishProbe("healthy", "") == nil {
+			m.lastProbe = now
+		}
+	}
+}
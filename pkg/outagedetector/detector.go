@@ -0,0 +1,115 @@
+package outagedetector
+
+import (
+	"fmt"
+	"time"
+)
+
+// State is what the detector believes about the mains power.
+type State int
+
+const (
+	Powered State = iota
+	Outage
+)
+
+func (s State) String() string {
+	if s == Outage {
+		return "outage"
+	}
+	return "powered"
+}
+
+// Config tunes how eager the detector is to switch state.
+type Config struct {
+	// EnterThreshold_mA is the current below which a sample counts towards
+	// an outage. The battery current is negative while discharging.
+	EnterThreshold_mA float32
+	// ExitThreshold_mA is the current at or above which a sample counts
+	// towards the power being back. It must not be below EnterThreshold_mA,
+	// the gap between both is the hysteresis.
+	ExitThreshold_mA float32
+	// Samples is how many consecutive samples are needed to switch state.
+	Samples int
+	// MinDwell is how long the readings must stay past the threshold, from
+	// the first to the last of the consecutive samples, to switch state.
+	MinDwell time.Duration
+	// ConfirmBusVoltage_V, when set, only lets an outage start if the bus
+	// voltage is at or below it. While powered, the charger holds the
+	// battery above this voltage.
+	ConfirmBusVoltage_V float32
+}
+
+// Sample is a reading of the power sensor.
+type Sample struct {
+	Time         time.Time
+	Current_mA   float32
+	BusVoltage_V float32
+}
+
+// Detector debounces the sensor readings into powered and outage states.
+type Detector struct {
+	config Config
+	state  State
+	// streak counts the consecutive samples pointing to the other state,
+	// the first of them was taken at since.
+	streak int
+	since  time.Time
+}
+
+// New returns a detector starting in the initial state.
+func New(config Config, initial State) (*Detector, error) {
+	if config.ExitThreshold_mA < config.EnterThreshold_mA {
+		return nil, fmt.Errorf(
+			"exit threshold (%vmA) must not be below the enter threshold (%vmA)",
+			config.ExitThreshold_mA, config.EnterThreshold_mA)
+	}
+	if config.Samples < 1 {
+		config.Samples = 1
+	}
+	if config.MinDwell < 0 {
+		return nil, fmt.Errorf("min dwell can't be negative, got %v", config.MinDwell)
+	}
+	return &Detector{config: config, state: initial}, nil
+}
+
+// State returns the current state.
+func (d *Detector) State() State {
+	return d.state
+}
+
+// Update feeds a new sample and returns the resulting state, and whether it
+// changed with this sample.
+func (d *Detector) Update(s Sample) (State, bool) {
+	if !d.pointsAway(s) {
+		d.streak = 0
+		return d.state, false
+	}
+
+	if d.streak == 0 {
+		d.since = s.Time
+	}
+	d.streak++
+	if d.streak < d.config.Samples || s.Time.Sub(d.since) < d.config.MinDwell {
+		return d.state, false
+	}
+
+	d.streak = 0
+	if d.state == Powered {
+		d.state = Outage
+	} else {
+		d.state = Powered
+	}
+	return d.state, true
+}
+
+// pointsAway returns whether the sample suggests leaving the current state.
+func (d *Detector) pointsAway(s Sample) bool {
+	if d.state == Outage {
+		return s.Current_mA >= d.config.ExitThreshold_mA
+	}
+	if s.Current_mA >= d.config.EnterThreshold_mA {
+		return false
+	}
+	return d.config.ConfirmBusVoltage_V == 0 || s.BusVoltage_V <= d.config.ConfirmBusVoltage_V
+}
@@ -0,0 +1,136 @@
+package outagedetector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetector(t *testing.T) {
+	legacy := Config{EnterThreshold_mA: -10, ExitThreshold_mA: -10, Samples: 1}
+	debounced := Config{EnterThreshold_mA: -10, ExitThreshold_mA: 0, Samples: 3}
+
+	tests := []struct {
+		name     string
+		config   Config
+		initial  State
+		currents []float32
+		// want is the state after each sample.
+		want []State
+	}{
+		{
+			name:     "single sample flips without debouncing",
+			config:   legacy,
+			currents: []float32{500, -11, -10, -800},
+			want:     []State{Powered, Outage, Powered, Outage},
+		},
+		{
+			name:     "noise around zero doesn't start an outage",
+			config:   debounced,
+			currents: []float32{-12, 3, -15, -11, 2, -20, -1},
+			want:     []State{Powered, Powered, Powered, Powered, Powered, Powered, Powered},
+		},
+		{
+			name:     "consecutive samples start and end an outage",
+			config:   debounced,
+			currents: []float32{-800, -800, -800, -800, 400, 400, 400},
+			want:     []State{Powered, Powered, Outage, Outage, Outage, Outage, Powered},
+		},
+		{
+			name:     "readings inside the hysteresis band keep the outage",
+			config:   debounced,
+			initial:  Outage,
+			currents: []float32{-5, -5, -5, -5, 1, -5, 1, 1, 1},
+			want:     []State{Outage, Outage, Outage, Outage, Outage, Outage, Outage, Outage, Powered},
+		},
+		{
+			name:     "the exit threshold is inclusive",
+			config:   Config{EnterThreshold_mA: -10, ExitThreshold_mA: 0, Samples: 1},
+			initial:  Outage,
+			currents: []float32{-1, 0},
+			want:     []State{Outage, Powered},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Len(t, tt.want, len(tt.currents))
+			d, err := New(tt.config, tt.initial)
+			require.NoError(t, err)
+			start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			for i, current := range tt.currents {
+				previous := d.State()
+				state, changed := d.Update(Sample{
+					Time:         start.Add(time.Duration(i) * 7 * time.Second),
+					Current_mA:   current,
+					BusVoltage_V: 3.9,
+				})
+				assert.Equal(t, tt.want[i], state, "sample %v", i)
+				assert.Equal(t, previous != state, changed, "sample %v", i)
+			}
+		})
+	}
+}
+
+func TestDetectorMinDwell(t *testing.T) {
+	d, err := New(Config{EnterThreshold_mA: -10, ExitThreshold_mA: 0, Samples: 1, MinDwell: 30 * time.Second}, Powered)
+	require.NoError(t, err)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		offset  time.Duration
+		current float32
+		want    State
+	}{
+		{0, -800, Powered},
+		{20 * time.Second, -800, Powered},
+		// A good sample restarts the dwell time.
+		{25 * time.Second, 100, Powered},
+		{30 * time.Second, -800, Powered},
+		{59 * time.Second, -800, Powered},
+		{60 * time.Second, -800, Outage},
+		{61 * time.Second, 100, Outage},
+		{91 * time.Second, 100, Powered},
+	}
+	for _, tt := range tests {
+		state, _ := d.Update(Sample{Time: start.Add(tt.offset), Current_mA: tt.current})
+		assert.Equal(t, tt.want, state, "at %v", tt.offset)
+	}
+}
+
+func TestDetectorBusVoltageConfirmation(t *testing.T) {
+	config := Config{EnterThreshold_mA: -10, ExitThreshold_mA: 0, Samples: 2, ConfirmBusVoltage_V: 4.0}
+	tests := []struct {
+		name     string
+		voltages []float32
+		want     State
+	}{
+		{"charger still holding the voltage", []float32{4.15, 4.15, 4.15}, Powered},
+		{"voltage dropped", []float32{3.95, 3.9}, Outage},
+		{"voltage recovered mid streak", []float32{3.95, 4.1, 3.9}, Powered},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := New(config, Powered)
+			require.NoError(t, err)
+			start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			for i, voltage := range tt.voltages {
+				d.Update(Sample{Time: start.Add(time.Duration(i) * time.Second), Current_mA: -500, BusVoltage_V: voltage})
+			}
+			assert.Equal(t, tt.want, d.State())
+		})
+	}
+}
+
+func TestNewValidatesConfig(t *testing.T) {
+	_, err := New(Config{EnterThreshold_mA: 0, ExitThreshold_mA: -10}, Powered)
+	assert.Error(t, err)
+	_, err = New(Config{MinDwell: -time.Second}, Powered)
+	assert.Error(t, err)
+
+	d, err := New(Config{EnterThreshold_mA: -10, ExitThreshold_mA: -10}, Outage)
+	require.NoError(t, err)
+	assert.Equal(t, Outage, d.State())
+	assert.Equal(t, "outage", d.State().String())
+}
@@ -0,0 +1,368 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/code-for-venezuela/poweroutage/pkg/clock"
+	"github.com/code-for-venezuela/poweroutage/pkg/store"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Config limits how much the outbox keeps while the publisher is failing. A
+// zero value disables the limit. Outage events are kept until they are
+// delivered or rejected: they don't count towards the limits and are never
+// dropped by them.
+type Config struct {
+	// MaxAge drops the messages queued longer than this.
+	MaxAge time.Duration
+	// MaxMessages drops the oldest messages when more are queued.
+	MaxMessages int
+	// MaxBytes drops the oldest messages when their payloads add up to more.
+	MaxBytes int64
+	// MaxAttempts drops a message after failing to publish it this many
+	// times, so a message the publisher rejects doesn't hold up the rest.
+	MaxAttempts int
+}
+
+// Kind tells which Publisher method delivers a message.
+type Kind string
+
+const (
+	EventKind  Kind = "event"
+	OutageKind Kind = "outage"
+)
+
+// Message is a queued call to the publisher.
+type Message struct {
+	Seq       uint64 `json:"seq"`
+	Kind      Kind   `json:"kind"`
+	EventType string `json:"event_type,omitempty"`
+	// Payload is the JSON of the outage event for OutageKind messages.
+	Payload    []byte    `json:"payload"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	Attempts   int       `json:"attempts"`
+	LastError  string    `json:"last_error,omitempty"`
+}
+
+func (m Message) size() int64 {
+	return int64(len(m.Payload))
+}
+
+// Outbox is a Publisher that stores every message in dir and delivers them
+// to the wrapped publisher, oldest first, when Drain is called. Messages
+// survive restarts and the device being offline, within the limits of
+// Config.
+type Outbox struct {
+	mu        sync.Mutex
+	dir       string
+	publisher store.Publisher
+	config    Config
+	clock     clock.Clock
+	nextSeq   uint64
+	// queued indexes the messages on disk, oldest first, so the limits are
+	// enforced without reading them.
+	queued []entry
+}
+
+type entry struct {
+	seq        uint64
+	kind       Kind
+	enqueuedAt time.Time
+	size       int64
+}
+
+// errBadMessage is returned when a message can't be parsed. The file is moved
+// out of the way.
+var errBadMessage = errors.New("bad outbox message")
+
+// Open creates the outbox in dir, keeping the messages queued by a previous
+// run.
+func Open(dir string, publisher store.Publisher, config Config) (*Outbox, error) {
+	return open(dir, publisher, config, clock.Real())
+}
+
+func open(dir string, publisher store.Publisher, config Config, clk clock.Clock) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating outbox directory: %v", err)
+	}
+	o := &Outbox{dir: dir, publisher: publisher, config: config, clock: clk, nextSeq: 1}
+	if err := o.load(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// load indexes the messages on disk and removes the leftovers of interrupted
+// writes.
+func (o *Outbox) load() error {
+	entries, err := os.ReadDir(o.dir)
+	if err != nil {
+		return fmt.Errorf("error reading outbox: %v", err)
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			continue
+		}
+		if filepath.Ext(name) == ".tmp" {
+			log.Warnf("Removing leftover of an interrupted write: %v", filepath.Join(o.dir, name))
+			if err := os.Remove(filepath.Join(o.dir, name)); err != nil {
+				return fmt.Errorf("error removing %v: %v", name, err)
+			}
+			continue
+		}
+		if filepath.Ext(name) != ".json" {
+			continue
+		}
+		if _, err := strconv.ParseUint(strings.TrimSuffix(name, ".json"), 10, 64); err != nil {
+			continue
+		}
+		m, err := o.read(name)
+		if errors.Is(err, errBadMessage) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		o.queued = append(o.queued, entry{seq: m.Seq, kind: m.Kind, enqueuedAt: m.EnqueuedAt, size: m.size()})
+	}
+	sort.Slice(o.queued, func(i, j int) bool { return o.queued[i].seq < o.queued[j].seq })
+	if len(o.queued) > 0 {
+		o.nextSeq = o.queued[len(o.queued)-1].seq + 1
+	}
+	return nil
+}
+
+// Publish queues a message for publisher.Publish.
+func (o *Outbox) Publish(eventType string, payload []byte) error {
+	return o.enqueue(Message{Kind: EventKind, EventType: eventType, Payload: payload})
+}
+
+// PublishOutageEvent queues a message for publisher.PublishOutageEvent.
+func (o *Outbox) PublishOutageEvent(event store.OutageEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to serialize outage event: %v", err)
+	}
+	return o.enqueue(Message{Kind: OutageKind, Payload: payload})
+}
+
+// Close closes the wrapped publisher. Queued messages are kept for the next
+// run.
+func (o *Outbox) Close() error {
+	return o.publisher.Close()
+}
+
+// Len returns the number of queued messages.
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.queued)
+}
+
+func (o *Outbox) enqueue(m Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	m.Seq = o.nextSeq
+	m.EnqueuedAt = o.clock.Now()
+	if err := o.write(m); err != nil {
+		return err
+	}
+	o.nextSeq++
+	o.queued = append(o.queued, entry{seq: m.Seq, kind: m.Kind, enqueuedAt: m.EnqueuedAt, size: m.size()})
+	return o.trim()
+}
+
+// Drain delivers the queued messages in order. It stops at the first message
+// that fails, which is retried on the next drain, so a later message is never
+// delivered before it, e.g. the end of an outage before its start. A message
+// that fails for good, or MaxAttempts times, is dropped instead. Drain also
+// stops after the message in flight when ctx is done.
+func (o *Outbox) Drain(ctx context.Context) error {
+	for ctx.Err() == nil {
+		m, ok, err := o.next()
+		if err != nil || !ok {
+			return err
+		}
+		err = o.deliver(m)
+		if err == nil || errors.Is(err, store.ErrAlreadyPublished) {
+			if err := o.remove(m.Seq); err != nil {
+				return err
+			}
+			continue
+		}
+		dropped, ferr := o.failed(m, err)
+		if ferr != nil {
+			return ferr
+		}
+		if !dropped {
+			log.Warnf("could not publish message %v, will retry later: %v", m.Seq, err)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (o *Outbox) deliver(m Message) error {
+	if m.Kind == OutageKind {
+		var event store.OutageEvent
+		if err := json.Unmarshal(m.Payload, &event); err != nil {
+			return fmt.Errorf("bad outage event in message %v: %v", m.Seq, err)
+		}
+		return o.publisher.PublishOutageEvent(event)
+	}
+	return o.publisher.Publish(m.EventType, m.Payload)
+}
+
+// next returns the oldest message, after dropping the expired ones.
+func (o *Outbox) next() (Message, bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.trim(); err != nil {
+		return Message{}, false, err
+	}
+	for len(o.queued) > 0 {
+		seq := o.queued[0].seq
+		m, err := o.read(filepath.Base(o.path(seq)))
+		if errors.Is(err, errBadMessage) {
+			o.queued = o.queued[1:]
+			continue
+		}
+		if err != nil {
+			return Message{}, false, err
+		}
+		return m, true, nil
+	}
+	return Message{}, false, nil
+}
+
+// failed records a failed attempt and reports whether the message was
+// dropped, because the publisher rejected it for good or it reached
+// MaxAttempts. Outage events are only dropped when rejected.
+func (o *Outbox) failed(m Message, cause error) (bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	m.Attempts++
+	m.LastError = cause.Error()
+	if store.IsPermanent(cause) {
+		log.Errorf("dropping message %v, it was rejected: %v", m.Seq, cause)
+		return true, o.removeLocked(m.Seq)
+	}
+	if m.Kind != OutageKind && o.config.MaxAttempts > 0 && m.Attempts >= o.config.MaxAttempts {
+		log.Errorf("dropping message %v after %v attempts: %v", m.Seq, m.Attempts, cause)
+		return true, o.removeLocked(m.Seq)
+	}
+	// The message may have been dropped by trim while it was published.
+	if !o.isQueued(m.Seq) {
+		return false, nil
+	}
+	return false, o.write(m)
+}
+
+func (o *Outbox) isQueued(seq uint64) bool {
+	for _, e := range o.queued {
+		if e.seq == seq {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *Outbox) remove(seq uint64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.removeLocked(seq)
+}
+
+func (o *Outbox) removeLocked(seq uint64) error {
+	if err := os.Remove(o.path(seq)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing message %v: %v", seq, err)
+	}
+	for i, e := range o.queued {
+		if e.seq == seq {
+			o.queued = append(o.queued[:i:i], o.queued[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// trim drops the messages that are too old, then the oldest ones until the
+// outbox is within its size limits. Outage events are left alone.
+func (o *Outbox) trim() error {
+	now := o.clock.Now()
+	var evictable []entry
+	var total int64
+	for _, e := range o.queued {
+		if e.kind == OutageKind {
+			continue
+		}
+		evictable = append(evictable, e)
+		total += e.size
+	}
+	for len(evictable) > 0 {
+		e := evictable[0]
+		expired := o.config.MaxAge > 0 && now.Sub(e.enqueuedAt) > o.config.MaxAge
+		full := (o.config.MaxMessages > 0 && len(evictable) > o.config.MaxMessages) ||
+			(o.config.MaxBytes > 0 && total > o.config.MaxBytes)
+		if !expired && !full {
+			return nil
+		}
+		if expired {
+			log.Warnf("dropping message %v queued at %v, it is too old", e.seq, e.enqueuedAt)
+		} else {
+			log.Warnf("outbox is full, dropping message %v queued at %v", e.seq, e.enqueuedAt)
+		}
+		if err := o.removeLocked(e.seq); err != nil {
+			return err
+		}
+		evictable = evictable[1:]
+		total -= e.size
+	}
+	return nil
+}
+
+func (o *Outbox) path(seq uint64) string {
+	return filepath.Join(o.dir, fmt.Sprintf("%020d.json", seq))
+}
+
+func (o *Outbox) read(name string) (Message, error) {
+	var m Message
+	path := filepath.Join(o.dir, name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return m, fmt.Errorf("error reading message: %v", err)
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		// Messages are written atomically, this file was damaged some other
+		// way. Keep it for inspection, out of the way of the rest.
+		log.Errorf("moving bad outbox message %v: %v", path, err)
+		if err := os.Rename(path, path+".corrupt"); err != nil {
+			return m, fmt.Errorf("error moving bad message: %v", err)
+		}
+		return m, fmt.Errorf("%w %v: %v", errBadMessage, name, err)
+	}
+	return m, nil
+}
+
+func (o *Outbox) write(m Message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to serialize message: %v", err)
+	}
+	if err := store.WriteFileAtomic(o.path(m.Seq), data, 0644); err != nil {
+		return fmt.Errorf("error writing message %v: %v", m.Seq, err)
+	}
+	return nil
+}
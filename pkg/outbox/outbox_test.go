@@ -0,0 +1,263 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/code-for-venezuela/poweroutage/pkg/clock"
+	"github.com/code-for-venezuela/poweroutage/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errOffline = errors.New("network is unreachable")
+
+type delivered struct {
+	eventType string
+	payload   string
+}
+
+// flakyPublisher fails the first failures calls, then succeeds. It always
+// fails to publish the payload bad.
+type flakyPublisher struct {
+	failures  int
+	calls     int
+	err       error
+	bad       string
+	delivered []delivered
+}
+
+func (p *flakyPublisher) Publish(eventType string, payload []byte) error {
+	p.calls++
+	if p.calls <= p.failures || (p.bad != "" && string(payload) == p.bad) {
+		return errOffline
+	}
+	if p.err != nil {
+		return p.err
+	}
+	p.delivered = append(p.delivered, delivered{eventType, string(payload)})
+	return nil
+}
+
+func (p *flakyPublisher) PublishOutageEvent(event store.OutageEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return p.Publish("power_outage_incident", payload)
+}
+
+func (p *flakyPublisher) Close() error {
+	return nil
+}
+
+func newOutbox(t *testing.T, dir string, publisher store.Publisher, config Config, clk *clock.Fake) *Outbox {
+	o, err := open(dir, publisher, config, clk)
+	require.NoError(t, err)
+	return o
+}
+
+func newClock() *clock.Fake {
+	return clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+}
+
+func TestOutboxRetriesUntilPublished(t *testing.T) {
+	dir := t.TempDir()
+	publisher := &flakyPublisher{failures: 3}
+	o := newOutbox(t, dir, publisher, Config{}, newClock())
+
+	require.NoError(t, o.Publish("power_outage_probe", []byte(`{"status":"restarting"}`)))
+	require.NoError(t, o.PublishOutageEvent(store.OutageEvent{ID: "outage-1", Status: store.Ongoing}))
+	require.NoError(t, o.Publish("power_outage_probe", []byte(`{"status":"healthy"}`)))
+
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, o.Drain(context.Background()))
+		assert.Empty(t, publisher.delivered)
+		assert.Equal(t, 3, o.Len())
+		// The attempts are recorded on the message at the head.
+		m, err := o.read(filepath.Base(o.path(1)))
+		require.NoError(t, err)
+		assert.Equal(t, i, m.Attempts)
+		assert.Equal(t, errOffline.Error(), m.LastError)
+	}
+
+	require.NoError(t, o.Drain(context.Background()))
+	require.Len(t, publisher.delivered, 3)
+	assert.Equal(t, delivered{"power_outage_probe", `{"status":"restarting"}`}, publisher.delivered[0])
+	assert.Equal(t, "power_outage_incident", publisher.delivered[1].eventType)
+	assert.Contains(t, publisher.delivered[1].payload, `"id":"outage-1"`)
+	assert.Equal(t, delivered{"power_outage_probe", `{"status":"healthy"}`}, publisher.delivered[2])
+	assert.Zero(t, o.Len())
+}
+
+func TestOutboxSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	clk := newClock()
+	o := newOutbox(t, dir, &flakyPublisher{failures: 1}, Config{}, clk)
+	require.NoError(t, o.Publish("a", []byte("1")))
+	require.NoError(t, o.Drain(context.Background()))
+	require.NoError(t, o.Publish("b", []byte("2")))
+	// A write cut short by a power loss.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "00000000000000000003.json.123.tmp"), []byte("{"), 0644))
+
+	publisher := &flakyPublisher{}
+	o = newOutbox(t, dir, publisher, Config{}, clk)
+	assert.Equal(t, 2, o.Len())
+	require.NoError(t, o.Publish("c", []byte("3")))
+	require.NoError(t, o.Drain(context.Background()))
+	assert.Equal(t, []delivered{{"a", "1"}, {"b", "2"}, {"c", "3"}}, publisher.delivered)
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestOutboxLimits(t *testing.T) {
+	t.Run("max messages", func(t *testing.T) {
+		publisher := &flakyPublisher{}
+		o := newOutbox(t, t.TempDir(), publisher, Config{MaxMessages: 2}, newClock())
+		for _, payload := range []string{"1", "2", "3"} {
+			require.NoError(t, o.Publish("probe", []byte(payload)))
+		}
+		require.NoError(t, o.Drain(context.Background()))
+		assert.Equal(t, []delivered{{"probe", "2"}, {"probe", "3"}}, publisher.delivered)
+	})
+
+	t.Run("max bytes", func(t *testing.T) {
+		publisher := &flakyPublisher{}
+		o := newOutbox(t, t.TempDir(), publisher, Config{MaxBytes: 8}, newClock())
+		for _, payload := range []string{"aaaa", "bbbb", "cccc"} {
+			require.NoError(t, o.Publish("probe", []byte(payload)))
+		}
+		require.NoError(t, o.Drain(context.Background()))
+		assert.Equal(t, []delivered{{"probe", "bbbb"}, {"probe", "cccc"}}, publisher.delivered)
+	})
+
+	t.Run("max age", func(t *testing.T) {
+		clk := newClock()
+		publisher := &flakyPublisher{}
+		o := newOutbox(t, t.TempDir(), publisher, Config{MaxAge: time.Hour}, clk)
+		require.NoError(t, o.Publish("probe", []byte("old")))
+		clk.Advance(30 * time.Minute)
+		require.NoError(t, o.Publish("probe", []byte("new")))
+		clk.Advance(45 * time.Minute)
+		require.NoError(t, o.Drain(context.Background()))
+		assert.Equal(t, []delivered{{"probe", "new"}}, publisher.delivered)
+	})
+
+	t.Run("max attempts", func(t *testing.T) {
+		publisher := &flakyPublisher{bad: "failing"}
+		o := newOutbox(t, t.TempDir(), publisher, Config{MaxAttempts: 2}, newClock())
+		require.NoError(t, o.Publish("probe", []byte("failing")))
+		require.NoError(t, o.Publish("probe", []byte("next")))
+		require.NoError(t, o.Drain(context.Background()))
+		assert.Empty(t, publisher.delivered)
+		// The second failure drops the message and moves on to the next.
+		require.NoError(t, o.Drain(context.Background()))
+		assert.Equal(t, []delivered{{"probe", "next"}}, publisher.delivered)
+		assert.Zero(t, o.Len())
+	})
+}
+
+func TestOutboxKeepsOutageEvents(t *testing.T) {
+	clk := newClock()
+	publisher := &flakyPublisher{failures: 3}
+	config := Config{MaxAge: time.Hour, MaxMessages: 1, MaxBytes: 8, MaxAttempts: 1}
+	o := newOutbox(t, t.TempDir(), publisher, config, clk)
+	require.NoError(t, o.PublishOutageEvent(store.OutageEvent{ID: "outage-1", Status: store.Resolved}))
+	require.NoError(t, o.Publish("probe", []byte("1")))
+	require.NoError(t, o.Publish("probe", []byte("2")))
+	assert.Equal(t, 2, o.Len())
+
+	// Offline for longer than MaxAge and MaxAttempts.
+	clk.Advance(2 * time.Hour)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, o.Drain(context.Background()))
+		assert.Equal(t, 1, o.Len())
+	}
+
+	require.NoError(t, o.Drain(context.Background()))
+	require.Len(t, publisher.delivered, 1)
+	assert.Contains(t, publisher.delivered[0].payload, `"id":"outage-1"`)
+	assert.Zero(t, o.Len())
+}
+
+func TestOutboxKeepsOrderWhenAMessageFails(t *testing.T) {
+	publisher := &flakyPublisher{bad: `{"id":"outage-1","status":"ongoing"}`}
+	o := newOutbox(t, t.TempDir(), publisher, Config{}, newClock())
+	require.NoError(t, o.Publish("probe", []byte("1")))
+	require.NoError(t, o.Publish("probe", []byte(`{"id":"outage-1","status":"ongoing"}`)))
+	require.NoError(t, o.Publish("probe", []byte(`{"id":"outage-1","status":"resolved"}`)))
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, o.Drain(context.Background()))
+		// The end of the outage waits for its start.
+		assert.Equal(t, []delivered{{"probe", "1"}}, publisher.delivered)
+		assert.Equal(t, 2, o.Len())
+	}
+
+	publisher.bad = ""
+	require.NoError(t, o.Drain(context.Background()))
+	assert.Equal(t, []delivered{
+		{"probe", "1"},
+		{"probe", `{"id":"outage-1","status":"ongoing"}`},
+		{"probe", `{"id":"outage-1","status":"resolved"}`},
+	}, publisher.delivered)
+}
+
+func TestOutboxDropsRejectedMessages(t *testing.T) {
+	rejecting := &flakyPublisher{err: store.Permanent(errors.New("bad request"))}
+	o := newOutbox(t, t.TempDir(), rejecting, Config{}, newClock())
+	require.NoError(t, o.Publish("probe", []byte("1")))
+	require.NoError(t, o.Publish("probe", []byte("2")))
+	require.NoError(t, o.Drain(context.Background()))
+	assert.Zero(t, o.Len())
+}
+
+func TestOutboxAlreadyPublished(t *testing.T) {
+	o := newOutbox(t, t.TempDir(), &flakyPublisher{err: store.ErrAlreadyPublished}, Config{}, newClock())
+	require.NoError(t, o.PublishOutageEvent(store.OutageEvent{ID: "outage-1", Status: store.Resolved}))
+	require.NoError(t, o.Drain(context.Background()))
+	assert.Zero(t, o.Len())
+}
+
+// cancellingPublisher cancels the drain after delivering a message.
+type cancellingPublisher struct {
+	flakyPublisher
+	cancel context.CancelFunc
+}
+
+func (p *cancellingPublisher) Publish(eventType string, payload []byte) error {
+	p.cancel()
+	return p.flakyPublisher.Publish(eventType, payload)
+}
+
+func TestOutboxDrainStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	publisher := &cancellingPublisher{cancel: cancel}
+	o := newOutbox(t, t.TempDir(), publisher, Config{}, newClock())
+	require.NoError(t, o.Publish("probe", []byte("1")))
+	require.NoError(t, o.Publish("probe", []byte("2")))
+
+	require.NoError(t, o.Drain(ctx))
+	assert.Len(t, publisher.delivered, 1)
+	assert.Equal(t, 1, o.Len())
+}
+
+func TestOutboxKeepsBadMessage(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "00000000000000000001.json"), []byte("{"), 0644))
+	publisher := &flakyPublisher{}
+	o := newOutbox(t, dir, publisher, Config{}, newClock())
+	assert.Zero(t, o.Len())
+	require.NoError(t, o.Publish("probe", []byte("1")))
+	require.NoError(t, o.Drain(context.Background()))
+	assert.Equal(t, []delivered{{"probe", "1"}}, publisher.delivered)
+	assert.FileExists(t, filepath.Join(dir, "00000000000000000001.json.corrupt"))
+}
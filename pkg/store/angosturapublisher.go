@@ -1,30 +1,170 @@
 package store
 
 import (
-	"encoding/base64"
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
-	"strings"
+	"time"
 )
 
-type AngosturaUploader struct {
+// Envelope is the body of every request to Angostura.
+type Envelope struct {
+	Type    string `json:"type"`
+	Version string `json:"version"`
+	// Payload is sent base64 encoded.
+	Payload []byte `json:"payload"`
+}
+
+// EnvelopeVersion is the version of the envelope format.
+const EnvelopeVersion = "1"
+
+// DefaultAPIKeyHeader is the header the API key is sent in, unless
+// AngosturaConfig says otherwise.
+const DefaultAPIKeyHeader = "X-API-Key"
+
+// AngosturaConfig holds the settings of the Angostura publisher.
+type AngosturaConfig struct {
 	Endpoint string
+	// Timeout bounds a whole request, 10 seconds when zero.
+	Timeout time.Duration
+	// BearerToken is sent in the Authorization header when set.
+	BearerToken string
+	// APIKey is sent in APIKeyHeader when set.
+	APIKey       string
+	APIKeyHeader string
 }
 
+type AngosturaUploader struct {
+	Endpoint     string
+	Client       *http.Client
+	BearerToken  string
+	APIKey       string
+	APIKeyHeader string
+}
+
+// NewAngosturaPubliser returns a publisher to endpoint with the default
+// timeouts and no authentication.
 func NewAngosturaPubliser(endpoint string) Publisher {
-	return &AngosturaUploader{Endpoint: endpoint}
+	return NewAngosturaUploader(AngosturaConfig{Endpoint: endpoint})
+}
+
+// NewAngosturaUploader returns a publisher posting to config.Endpoint.
+func NewAngosturaUploader(config AngosturaConfig) *AngosturaUploader {
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.APIKeyHeader == "" {
+		config.APIKeyHeader = DefaultAPIKeyHeader
+	}
+	return &AngosturaUploader{
+		Endpoint:     config.Endpoint,
+		Client:       newHTTPClient(config.Timeout),
+		BearerToken:  config.BearerToken,
+		APIKey:       config.APIKey,
+		APIKeyHeader: config.APIKeyHeader,
+	}
+}
+
+// newHTTPClient returns a client whose requests give up after timeout, and
+// sooner when connecting or waiting for the response headers.
+func newHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   timeout / 2,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSHandshakeTimeout:   timeout / 2,
+			ResponseHeaderTimeout: timeout,
+			IdleConnTimeout:       90 * time.Second,
+			MaxIdleConns:          2,
+		},
+	}
+}
+
+// HTTPError is returned when the server answers with a status other than 2xx.
+type HTTPError struct {
+	StatusCode int
+	// Body is the start of the response body.
+	Body string
+}
+
+func (e *HTTPError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("unexpected status %v", e.StatusCode)
+	}
+	return fmt.Sprintf("unexpected status %v: %v", e.StatusCode, e.Body)
 }
 
+// maxErrorBody is how much of the body of an error response is kept.
+const maxErrorBody = 512
+
+// Publish posts the payload in an Envelope. It returns a PermanentError when
+// the server rejects the message, which won't be accepted if sent again, and
+// any other error when it may.
 func (uploader *AngosturaUploader) Publish(eventType string, payload []byte) error {
-	base64Payload := base64.StdEncoding.EncodeToString(payload)
-	requestBody := strings.NewReader(fmt.Sprintf("{\"type\":\"%s\",\"version\":\"1\",\"payload\":\"%s\"}", eventType, base64Payload))
-	resp, err := http.Post(uploader.Endpoint, "application/json", requestBody)
+	body, err := json.Marshal(Envelope{Type: eventType, Version: EnvelopeVersion, Payload: payload})
 	if err != nil {
-		return err
+		return Permanent(fmt.Errorf("failed to serialize envelope: %v", err))
+	}
+	req, err := http.NewRequest(http.MethodPost, uploader.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(fmt.Errorf("error creating request to angostura: %v", err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if uploader.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+uploader.BearerToken)
+	}
+	if uploader.APIKey != "" {
+		header := uploader.APIKeyHeader
+		if header == "" {
+			header = DefaultAPIKeyHeader
+		}
+		req.Header.Set(header, uploader.APIKey)
+	}
+
+	client := uploader.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error publishing to angostura: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		// Drain the body so the connection is reused.
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrorBody))
+		return nil
+	}
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	httpErr := &HTTPError{StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(snippet))}
+	if retryableStatus(resp.StatusCode) {
+		return fmt.Errorf("error publishing to angostura: %w", httpErr)
+	}
+	return Permanent(fmt.Errorf("angostura rejected the message: %w", httpErr))
+}
+
+// retryableStatus tells whether a request that got status may succeed later.
+// Authentication failures are retried, as the credentials of the device may
+// be fixed, and dropping every message meanwhile would lose them all.
+func retryableStatus(status int) bool {
+	switch {
+	case status >= 500:
+		return true
+	case status == http.StatusRequestTimeout,
+		status == http.StatusTooManyRequests,
+		status == http.StatusUnauthorized,
+		status == http.StatusForbidden:
+		return true
+	default:
+		return false
 	}
-	resp.Body.Close()
-	return nil
 }
 
 func (uploader *AngosturaUploader) PublishOutageEvent(event OutageEvent) error {
@@ -32,9 +172,12 @@ func (uploader *AngosturaUploader) PublishOutageEvent(event OutageEvent) error {
 	if err != nil {
 		return err
 	}
-	return uploader.Publish("power_outage_incident", payload)
+	return uploader.Publish(OutageEventType, payload)
 }
 
 func (uploader *AngosturaUploader) Close() error {
+	if uploader.Client != nil {
+		uploader.Client.CloseIdleConnections()
+	}
 	return nil
 }
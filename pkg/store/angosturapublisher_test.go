@@ -0,0 +1,127 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type angosturaRequest struct {
+	header   http.Header
+	envelope Envelope
+}
+
+// angosturaServer answers every request with status and records them.
+func angosturaServer(t *testing.T, status int, requests *[]angosturaRequest) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		var envelope Envelope
+		require.NoError(t, json.Unmarshal(body, &envelope))
+		*requests = append(*requests, angosturaRequest{header: r.Header.Clone(), envelope: envelope})
+		w.WriteHeader(status)
+		io.WriteString(w, "  server says no \n")
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestAngosturaEnvelope(t *testing.T) {
+	var requests []angosturaRequest
+	server := angosturaServer(t, http.StatusAccepted, &requests)
+	uploader := NewAngosturaUploader(AngosturaConfig{Endpoint: server.URL})
+
+	// A type with quotes used to break the JSON.
+	require.NoError(t, uploader.Publish(`probe "v2"`, []byte(`{"status":"healthy"}`)))
+	require.Len(t, requests, 1)
+	assert.Equal(t, "application/json", requests[0].header.Get("Content-Type"))
+	assert.Equal(t, Envelope{Type: `probe "v2"`, Version: "1", Payload: []byte(`{"status":"healthy"}`)}, requests[0].envelope)
+	assert.Empty(t, requests[0].header.Get("Authorization"))
+	assert.Empty(t, requests[0].header.Get(DefaultAPIKeyHeader))
+
+	require.NoError(t, uploader.PublishOutageEvent(OutageEvent{ID: "outage-1", Status: Ongoing}))
+	require.Len(t, requests, 2)
+	assert.Equal(t, "power_outage_incident", requests[1].envelope.Type)
+	var event OutageEvent
+	require.NoError(t, json.Unmarshal(requests[1].envelope.Payload, &event))
+	assert.Equal(t, "outage-1", event.ID)
+}
+
+func TestAngosturaAuth(t *testing.T) {
+	var requests []angosturaRequest
+	server := angosturaServer(t, http.StatusOK, &requests)
+
+	bearer := NewAngosturaUploader(AngosturaConfig{Endpoint: server.URL, BearerToken: "secret"})
+	require.NoError(t, bearer.Publish("probe", nil))
+	apiKey := NewAngosturaUploader(AngosturaConfig{Endpoint: server.URL, APIKey: "key", APIKeyHeader: "X-Device-Key"})
+	require.NoError(t, apiKey.Publish("probe", nil))
+
+	require.Len(t, requests, 2)
+	assert.Equal(t, "Bearer secret", requests[0].header.Get("Authorization"))
+	assert.Equal(t, "key", requests[1].header.Get("X-Device-Key"))
+	assert.Empty(t, requests[1].header.Get("Authorization"))
+}
+
+func TestAngosturaErrors(t *testing.T) {
+	tests := []struct {
+		status    int
+		permanent bool
+	}{
+		{http.StatusInternalServerError, false},
+		{http.StatusBadGateway, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusUnauthorized, false},
+		{http.StatusBadRequest, true},
+		{http.StatusUnprocessableEntity, true},
+		{http.StatusNotFound, true},
+	}
+	for _, test := range tests {
+		t.Run(http.StatusText(test.status), func(t *testing.T) {
+			var requests []angosturaRequest
+			server := angosturaServer(t, test.status, &requests)
+			uploader := NewAngosturaUploader(AngosturaConfig{Endpoint: server.URL})
+
+			err := uploader.Publish("probe", []byte("{}"))
+			require.Error(t, err)
+			assert.Equal(t, test.permanent, IsPermanent(err))
+			var httpErr *HTTPError
+			require.True(t, errors.As(err, &httpErr))
+			assert.Equal(t, test.status, httpErr.StatusCode)
+			assert.Equal(t, "server says no", httpErr.Body)
+		})
+	}
+}
+
+func TestAngosturaTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	uploader := NewAngosturaUploader(AngosturaConfig{Endpoint: server.URL, Timeout: 50 * time.Millisecond})
+	start := time.Now()
+	err := uploader.Publish("probe", []byte("{}"))
+	require.Error(t, err)
+	assert.False(t, IsPermanent(err))
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestAngosturaUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	err := NewAngosturaUploader(AngosturaConfig{Endpoint: url}).Publish("probe", []byte("{}"))
+	require.Error(t, err)
+	assert.False(t, IsPermanent(err))
+}
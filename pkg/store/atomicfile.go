@@ -0,0 +1,124 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tempSuffix marks files that are being written. They are only complete once
+// renamed, so any left behind belong to a write that was cut short.
+const tempSuffix = ".tmp"
+
+// fileSystem is the subset of file operations used to write event files. The
+// recorder uses the real file system, tests replace it to inject faults.
+type fileSystem interface {
+	CreateTemp(dir, pattern string) (tempFile, error)
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+	// SyncDir flushes the entries of dir, e.g. a rename, to the disk.
+	SyncDir(dir string) error
+}
+
+type tempFile interface {
+	Name() string
+	Write(b []byte) (int, error)
+	Chmod(mode os.FileMode) error
+	Sync() error
+	Close() error
+}
+
+type osFileSystem struct{}
+
+func (osFileSystem) CreateTemp(dir, pattern string) (tempFile, error) {
+	return os.CreateTemp(dir, pattern)
+}
+
+func (osFileSystem) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (osFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFileSystem) SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// writeFileAtomic replaces path with data so that, even if power is cut
+// halfway, path holds either its previous contents or data. The data is
+// written and synced to a temporary file in the same directory, which is
+// then renamed over path, and the directory is synced so the rename survives
+// a power cut.
+func writeFileAtomic(fs fileSystem, path string, data []byte, perm os.FileMode) error {
+	dir, name := filepath.Split(path)
+	f, err := fs.CreateTemp(dir, name+".*"+tempSuffix)
+	if err != nil {
+		return fmt.Errorf("error creating temporary file: %v", err)
+	}
+	tmp := f.Name()
+	committed := false
+	defer func() {
+		if !committed {
+			fs.Remove(tmp)
+		}
+	}()
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing temporary file: %v", err)
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return fmt.Errorf("error setting permissions of temporary file: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("error syncing temporary file: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error closing temporary file: %v", err)
+	}
+	if err := fs.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error renaming temporary file: %v", err)
+	}
+	committed = true
+	if err := fs.SyncDir(dir); err != nil {
+		return fmt.Errorf("error syncing directory %v: %v", dir, err)
+	}
+	return nil
+}
+
+// renameDurable moves oldpath to newpath and syncs both directories.
+func renameDurable(fs fileSystem, oldpath, newpath string) error {
+	if err := fs.Rename(oldpath, newpath); err != nil {
+		return err
+	}
+	if err := fs.SyncDir(filepath.Dir(newpath)); err != nil {
+		return fmt.Errorf("error syncing directory %v: %v", filepath.Dir(newpath), err)
+	}
+	if err := fs.SyncDir(filepath.Dir(oldpath)); err != nil {
+		return fmt.Errorf("error syncing directory %v: %v", filepath.Dir(oldpath), err)
+	}
+	return nil
+}
+
+func isTempFile(name string) bool {
+	return strings.HasSuffix(name, tempSuffix)
+}
+
+// WriteFileAtomic replaces path with data. After a power cut path holds
+// either its previous contents or data, never a mix of both.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return writeFileAtomic(osFileSystem{}, path, data, perm)
+}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LastAlive is a file holding the last time the monitor was known to be
+// running. After a restart it tells how long the device was off, e.g. because
+// the battery ran out during an outage.
+type LastAlive struct {
+	path string
+	fs   fileSystem
+}
+
+func NewLastAlive(path string) *LastAlive {
+	return &LastAlive{path: path, fs: osFileSystem{}}
+}
+
+// Read returns the time stored in the file. The error satisfies
+// os.IsNotExist when the monitor never ran before.
+func (l *LastAlive) Read() (time.Time, error) {
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	timestamp, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error parsing last alive timestamp: %v", err)
+	}
+	return time.Unix(timestamp, 0), nil
+}
+
+// Write stores t, replacing the previous time atomically.
+func (l *LastAlive) Write(t time.Time) error {
+	data := []byte(strconv.FormatInt(t.Unix(), 10))
+	if err := writeFileAtomic(l.fs, l.path, data, 0644); err != nil {
+		return fmt.Errorf("error writing last alive file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package store
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// migrationFiles are the versioned changes to the MySQL schema, applied in
+// order by Migrate. They are named <version>_<description>.sql and must never
+// change once released, a change to the schema is a new file.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// ErrSchemaMismatch is returned by VerifySchema when the database isn't at
+// the schema version of this build.
+var ErrSchemaMismatch = errors.New("database schema version mismatch")
+
+type migration struct {
+	version    int
+	name       string
+	statements []string
+}
+
+// loadMigrations reads the migrations in dir of fsys, sorted by version.
+func loadMigrations(fsys fs.FS, dir string) ([]migration, error) {
+	files, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading migrations: %v", err)
+	}
+	var migrations []migration
+	for _, file := range files {
+		if file.IsDir() || path.Ext(file.Name()) != ".sql" {
+			continue
+		}
+		prefix, _, _ := strings.Cut(file.Name(), "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %v doesn't start with a version", file.Name())
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, file.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("error reading migration %v: %v", file.Name(), err)
+		}
+		migrations = append(migrations, migration{
+			version:    version,
+			name:       file.Name(),
+			statements: splitStatements(string(data)),
+		})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	for i, m := range migrations {
+		if m.version != i+1 {
+			return nil, fmt.Errorf("migration %v is out of sequence, expected version %v", m.name, i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// splitStatements splits a migration into its statements, dropping comments,
+// as the driver runs a single statement at a time.
+func splitStatements(script string) []string {
+	var lines []string
+	for _, line := range strings.Split(script, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	var statements []string
+	for _, statement := range strings.Split(strings.Join(lines, "\n"), ";") {
+		if statement = strings.TrimSpace(statement); statement != "" {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
+
+func embeddedMigrations() []migration {
+	migrations, err := loadMigrations(migrationFiles, "migrations")
+	if err != nil {
+		// The migrations are part of the binary, this is a bug.
+		panic(err)
+	}
+	return migrations
+}
+
+// LatestSchemaVersion is the schema version this build expects.
+func LatestSchemaVersion() int {
+	return len(embeddedMigrations())
+}
+
+const createSchemaVersion = `CREATE TABLE IF NOT EXISTS schema_version (
+    version INT NOT NULL PRIMARY KEY,
+    applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`
+
+// SchemaVersion returns the version of the schema of db, 0 when no migration
+// was applied.
+func SchemaVersion(db *sql.DB) (int, error) {
+	var version sql.NullInt64
+	err := db.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("error reading schema version: %v", err)
+	}
+	return int(version.Int64), nil
+}
+
+// Migrate applies the migrations newer than the schema version of db and
+// returns the version it is at afterwards. MySQL commits schema changes right
+// away, so a migration that fails halfway is left applied in part, which is
+// why migrations only use IF NOT EXISTS statements.
+func Migrate(db *sql.DB) (int, error) {
+	if _, err := db.Exec(createSchemaVersion); err != nil {
+		return 0, fmt.Errorf("error creating schema_version table: %v", err)
+	}
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range embeddedMigrations() {
+		if m.version <= current {
+			continue
+		}
+		log.Infof("Applying migration %v", m.name)
+		for _, statement := range m.statements {
+			if _, err := db.Exec(statement); err != nil {
+				return current, fmt.Errorf("error applying migration %v: %v", m.name, err)
+			}
+		}
+		if _, err := db.Exec("INSERT INTO schema_version (version) VALUES (?)", m.version); err != nil {
+			return current, fmt.Errorf("error recording migration %v: %v", m.name, err)
+		}
+		current = m.version
+	}
+	return current, nil
+}
+
+// VerifySchema returns ErrSchemaMismatch unless db is at the schema version
+// of this build.
+func VerifySchema(db *sql.DB) error {
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if latest := LatestSchemaVersion(); version != latest {
+		return fmt.Errorf("%w: the database is at version %v, expected %v, run the migrate command", ErrSchemaMismatch, version, latest)
+	}
+	return nil
+}
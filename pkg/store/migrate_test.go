@@ -0,0 +1,59 @@
+package store
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations := embeddedMigrations()
+	require.Len(t, migrations, LatestSchemaVersion())
+	require.NotEmpty(t, migrations)
+
+	var schema []string
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.version)
+		require.NotEmpty(t, m.statements, m.name)
+		schema = append(schema, m.statements...)
+	}
+	all := strings.Join(schema, "\n")
+	// The tables used by the publisher and the events reader.
+	assert.Contains(t, all, "CREATE TABLE IF NOT EXISTS Event (")
+	assert.Contains(t, all, "INDEX idx_event_type_created_at (event_type, created_at)")
+	assert.Contains(t, all, "CREATE TABLE IF NOT EXISTS OutageEvent (")
+	assert.Contains(t, all, "INDEX idx_device_id (device_id)")
+	assert.Contains(t, all, "estimated_empty_at DATETIME(6) NULL")
+	assert.Contains(t, all, "device_lost_power_at DATETIME(6) NULL")
+}
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/0002_second.sql": {Data: []byte("-- A comment; with a semicolon\nCREATE TABLE b (id INT);\nCREATE INDEX i ON b (id);\n")},
+		"m/0001_first.sql":  {Data: []byte("CREATE TABLE a (id INT);")},
+		"m/README":          {Data: []byte("not a migration")},
+	}
+	migrations, err := loadMigrations(fsys, "m")
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+	assert.Equal(t, "0001_first.sql", migrations[0].name)
+	assert.Equal(t, []string{"CREATE TABLE a (id INT)"}, migrations[0].statements)
+	assert.Equal(t, 2, migrations[1].version)
+	assert.Equal(t, []string{"CREATE TABLE b (id INT)", "CREATE INDEX i ON b (id)"}, migrations[1].statements)
+}
+
+func TestLoadMigrationsRejectsGaps(t *testing.T) {
+	_, err := loadMigrations(fstest.MapFS{
+		"m/0001_first.sql": {Data: []byte("SELECT 1;")},
+		"m/0003_third.sql": {Data: []byte("SELECT 1;")},
+	}, "m")
+	assert.Error(t, err)
+
+	_, err = loadMigrations(fstest.MapFS{
+		"m/first.sql": {Data: []byte("SELECT 1;")},
+	}, "m")
+	assert.Error(t, err)
+}
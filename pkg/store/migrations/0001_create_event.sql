@@ -0,0 +1,8 @@
+-- Event holds the probes, boot events and sensor faults as JSON payloads.
+CREATE TABLE IF NOT EXISTS Event (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    event_type VARCHAR(64) NOT NULL,
+    payload JSON NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_event_type_created_at (event_type, created_at)
+);
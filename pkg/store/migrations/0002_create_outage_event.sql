@@ -0,0 +1,15 @@
+-- OutageEvent holds one row per outage, upserted by ID when it starts and when
+-- it ends. status is 1 while ongoing and 2 once resolved. estimated_empty_at is
+-- when the battery of the device is expected to run out during the outage.
+-- device_lost_power_at is set when it did run out, the outage then ended at
+-- some point between it and end_time, when the device booted again.
+CREATE TABLE IF NOT EXISTS OutageEvent (
+    id CHAR(36) NOT NULL PRIMARY KEY,
+    status TINYINT NOT NULL,
+    start_time DATETIME(6) NOT NULL,
+    end_time DATETIME(6) NULL,
+    device_id VARCHAR(64) NOT NULL,
+    estimated_empty_at DATETIME(6) NULL,
+    device_lost_power_at DATETIME(6) NULL,
+    INDEX idx_device_id (device_id)
+);
@@ -0,0 +1,202 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/code-for-venezuela/poweroutage/pkg/events"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// MQTTConfig holds the settings of the MQTT publisher.
+type MQTTConfig struct {
+	// Broker is tcp://host:port or tls://host:port.
+	Broker    string
+	MonitorID string
+	Username  string
+	Password  string
+	// TopicPrefix is the root of the topics, poweroutage when empty.
+	TopicPrefix string
+	// KeepAlive is how long the broker waits, times 1.5, before marking the
+	// device offline, 60 seconds when zero.
+	KeepAlive time.Duration
+	// AckTimeout bounds connecting and waiting for the broker to acknowledge
+	// a message, 10 seconds when zero.
+	AckTimeout time.Duration
+}
+
+// DeviceStatus is the retained message on the status topic of a device.
+type DeviceStatus struct {
+	Status string `json:"status"`
+}
+
+// stateEventTypes are the messages whose latest one is retained on the state
+// topics, so a new subscriber learns the state of the device without waiting
+// for the next probe.
+var stateEventTypes = map[string]bool{
+	events.ProbeEventType:  true,
+	events.OutageEventType: true,
+}
+
+// mqttClient is the part of paho.Client used by the publisher.
+type mqttClient interface {
+	IsConnectionOpen() bool
+	Connect() paho.Token
+	Publish(topic string, qos byte, retained bool, payload interface{}) paho.Token
+	Disconnect(quiesce uint)
+}
+
+// MQTTPublisher publishes every message at QoS 1 to
+// <prefix>/<monitor ID>/events/<event type>. The status topic,
+// <prefix>/<monitor ID>/status, retains whether the device is online: it is
+// set when connecting, and the broker sets it offline through the will when
+// the device disappears. The latest probe and outage event are also retained
+// on <prefix>/<monitor ID>/state/<event type>. The session is kept through
+// reconnects.
+type MQTTPublisher struct {
+	topic      string
+	ackTimeout time.Duration
+
+	// mu serializes connecting.
+	mu     sync.Mutex
+	client mqttClient
+}
+
+// NewMQTTPublisher returns a publisher to config.Broker. It connects on the
+// first message.
+func NewMQTTPublisher(config MQTTConfig) (*MQTTPublisher, error) {
+	return newMQTTPublisher(config, func(opts *paho.ClientOptions) mqttClient {
+		return paho.NewClient(opts)
+	})
+}
+
+func newMQTTPublisher(config MQTTConfig, newClient func(*paho.ClientOptions) mqttClient) (*MQTTPublisher, error) {
+	if config.MonitorID == "" {
+		return nil, errors.New("the MQTT publisher needs a monitor ID")
+	}
+	if !strings.HasPrefix(config.Broker, "tcp://") && !strings.HasPrefix(config.Broker, "tls://") {
+		return nil, fmt.Errorf("invalid MQTT broker %q, use tcp://host:port or tls://host:port", config.Broker)
+	}
+	if config.TopicPrefix == "" {
+		config.TopicPrefix = "poweroutage"
+	}
+	if config.KeepAlive == 0 {
+		config.KeepAlive = 60 * time.Second
+	}
+	if config.AckTimeout == 0 {
+		config.AckTimeout = 10 * time.Second
+	}
+	p := &MQTTPublisher{
+		topic:      config.TopicPrefix + "/" + topicLevel(config.MonitorID),
+		ackTimeout: config.AckTimeout,
+	}
+	offline := p.status("offline")
+	opts := paho.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(config.MonitorID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetCleanSession(false).
+		SetKeepAlive(config.KeepAlive).
+		SetConnectTimeout(config.AckTimeout).
+		SetWriteTimeout(config.AckTimeout).
+		// Publish connects again, so a broker that is down fails the
+		// message instead of holding it in memory.
+		SetAutoReconnect(false).
+		SetBinaryWill(p.StatusTopic(), offline, 1, true).
+		// Replace the will retained while the device was away.
+		SetOnConnectHandler(func(paho.Client) {
+			p.client.Publish(p.StatusTopic(), 1, true, p.status("online"))
+		})
+	p.client = newClient(opts)
+	return p, nil
+}
+
+// StatusTopic returns the topic with the retained status of the device.
+func (p *MQTTPublisher) StatusTopic() string {
+	return p.topic + "/status"
+}
+
+// EventTopic returns the topic of the messages of eventType.
+func (p *MQTTPublisher) EventTopic(eventType string) string {
+	return p.topic + "/events/" + topicLevel(eventType)
+}
+
+// StateTopic returns the topic with the latest message of eventType, retained
+// for the probes and the outage events.
+func (p *MQTTPublisher) StateTopic(eventType string) string {
+	return p.topic + "/state/" + topicLevel(eventType)
+}
+
+func (p *MQTTPublisher) status(status string) []byte {
+	payload, _ := json.Marshal(DeviceStatus{Status: status})
+	return payload
+}
+
+// topicLevel keeps s from adding levels or wildcards to a topic.
+func topicLevel(s string) string {
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(s)
+}
+
+// connect connects to the broker unless the connection is open.
+func (p *MQTTPublisher) connect() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.client.IsConnectionOpen() {
+		return nil
+	}
+	return p.wait(p.client.Connect())
+}
+
+// wait waits for token up to the ack timeout.
+func (p *MQTTPublisher) wait(token paho.Token) error {
+	if !token.WaitTimeout(p.ackTimeout) {
+		return fmt.Errorf("timed out after %v waiting for the broker", p.ackTimeout)
+	}
+	return token.Error()
+}
+
+func (p *MQTTPublisher) Publish(eventType string, payload []byte) error {
+	if err := p.connect(); err != nil {
+		return fmt.Errorf("failed to connect to MQTT: %w", err)
+	}
+	if err := p.wait(p.client.Publish(p.EventTopic(eventType), 1, false, payload)); err != nil {
+		return fmt.Errorf("failed to publish to MQTT: %w", err)
+	}
+	if !stateEventTypes[eventType] {
+		return nil
+	}
+	if err := p.wait(p.client.Publish(p.StateTopic(eventType), 1, true, payload)); err != nil {
+		return fmt.Errorf("failed to publish the state to MQTT: %w", err)
+	}
+	return nil
+}
+
+func (p *MQTTPublisher) PublishOutageEvent(event OutageEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return p.Publish(OutageEventType, payload)
+}
+
+// Close marks the device offline, as the broker drops the will on a clean
+// disconnect, and disconnects.
+func (p *MQTTPublisher) Close() error {
+	// When not connected the broker already published the will, if it ever
+	// knew about the device.
+	if !p.client.IsConnectionOpen() {
+		return nil
+	}
+	err := p.wait(p.client.Publish(p.StatusTopic(), 1, true, p.status("offline")))
+	p.client.Disconnect(250)
+	if err != nil {
+		return fmt.Errorf("failed to publish offline status: %v", err)
+	}
+	return nil
+}
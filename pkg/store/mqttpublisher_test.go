@@ -0,0 +1,239 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/code-for-venezuela/poweroutage/pkg/events"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mqttMessage struct {
+	topic    string
+	qos      byte
+	retained bool
+	payload  []byte
+}
+
+// doneToken is a paho.Token that is already complete.
+type doneToken struct {
+	err error
+}
+
+func (t doneToken) Wait() bool                     { return true }
+func (t doneToken) WaitTimeout(time.Duration) bool { return true }
+func (t doneToken) Error() error                   { return t.err }
+
+func (t doneToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+// pendingToken is a paho.Token the broker never completes.
+type pendingToken struct {
+	doneToken
+}
+
+func (t pendingToken) WaitTimeout(time.Duration) bool { return false }
+
+// fakeBroker is a paho client that keeps what it publishes like a broker,
+// including the retained messages and the will.
+type fakeBroker struct {
+	opts *paho.ClientOptions
+
+	mu         sync.Mutex
+	connected  bool
+	connects   int
+	connectErr error
+	noAcks     bool
+	published  []mqttMessage
+	retained   map[string][]byte
+}
+
+func newFakeBroker(t *testing.T) (*MQTTPublisher, *fakeBroker) {
+	broker := &fakeBroker{retained: map[string][]byte{}}
+	publisher, err := newMQTTPublisher(MQTTConfig{Broker: "tcp://localhost:1883", MonitorID: "monitor/1"},
+		func(opts *paho.ClientOptions) mqttClient {
+			broker.opts = opts
+			return broker
+		})
+	require.NoError(t, err)
+	return publisher, broker
+}
+
+func (b *fakeBroker) IsConnectionOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.connected
+}
+
+func (b *fakeBroker) Connect() paho.Token {
+	b.mu.Lock()
+	if b.connectErr != nil {
+		b.mu.Unlock()
+		return doneToken{b.connectErr}
+	}
+	b.connected = true
+	b.connects++
+	b.mu.Unlock()
+	b.opts.OnConnect(nil)
+	return doneToken{}
+}
+
+func (b *fakeBroker) Publish(topic string, qos byte, retained bool, payload interface{}) paho.Token {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.noAcks {
+		return pendingToken{}
+	}
+	m := mqttMessage{topic, qos, retained, payload.([]byte)}
+	b.published = append(b.published, m)
+	if retained {
+		b.retained[topic] = m.payload
+	}
+	return doneToken{}
+}
+
+func (b *fakeBroker) Disconnect(quiesce uint) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.connected = false
+}
+
+// drop loses the connection, so the broker publishes the will.
+func (b *fakeBroker) drop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.connected = false
+	b.retained[b.opts.WillTopic] = b.opts.WillPayload
+}
+
+func (b *fakeBroker) status(t *testing.T, topic string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	payload, ok := b.retained[topic]
+	if !ok {
+		return ""
+	}
+	var status DeviceStatus
+	require.NoError(t, json.Unmarshal(payload, &status))
+	return status.Status
+}
+
+func TestMQTTPublisherTopics(t *testing.T) {
+	publisher, broker := newFakeBroker(t)
+	defer publisher.Close()
+
+	require.NoError(t, publisher.Publish("probe", []byte(`{"status":"healthy"}`)))
+	require.NoError(t, publisher.PublishOutageEvent(OutageEvent{ID: "outage-1", Status: Ongoing}))
+
+	var events []string
+	for _, m := range broker.published {
+		if m.retained {
+			continue
+		}
+		assert.Equal(t, byte(1), m.qos)
+		assert.False(t, m.retained)
+		events = append(events, m.topic)
+	}
+	assert.Equal(t, []string{
+		"poweroutage/monitor_1/events/probe",
+		"poweroutage/monitor_1/events/power_outage_incident",
+	}, events)
+
+	assert.Equal(t, 1, broker.connects)
+	assert.Equal(t, "monitor/1", broker.opts.ClientID)
+	assert.False(t, broker.opts.CleanSession)
+	assert.True(t, broker.opts.WillEnabled)
+	assert.Equal(t, "poweroutage/monitor_1/status", broker.opts.WillTopic)
+	assert.True(t, broker.opts.WillRetained)
+}
+
+func TestMQTTPublisherStatus(t *testing.T) {
+	publisher, broker := newFakeBroker(t)
+	status := func() string { return broker.status(t, publisher.StatusTopic()) }
+
+	require.NoError(t, publisher.Publish("probe", nil))
+	assert.Equal(t, "online", status())
+
+	// The broker marks the device offline when the connection is lost, and
+	// the device online again when it reconnects.
+	broker.drop()
+	assert.Equal(t, "offline", status())
+	require.NoError(t, publisher.Publish("probe", nil))
+	assert.Equal(t, "online", status())
+	assert.Equal(t, 2, broker.connects)
+
+	require.NoError(t, publisher.Close())
+	assert.Equal(t, "offline", status())
+	assert.False(t, broker.IsConnectionOpen())
+}
+
+func TestMQTTPublisherRetainsLastState(t *testing.T) {
+	publisher, broker := newFakeBroker(t)
+	defer publisher.Close()
+
+	probe := []byte(`{"status":"healthy"}`)
+	require.NoError(t, publisher.Publish(events.ProbeEventType, probe))
+	require.NoError(t, publisher.PublishOutageEvent(OutageEvent{ID: "outage-1", Status: Ongoing}))
+	require.NoError(t, publisher.PublishOutageEvent(OutageEvent{ID: "outage-1", Status: Resolved}))
+	require.NoError(t, publisher.Publish(events.BootEventType, []byte("{}")))
+	broker.drop()
+
+	// A new subscriber gets the retained messages of the device.
+	assert.Equal(t, "poweroutage/monitor_1/state/power_outage_probe", publisher.StateTopic(events.ProbeEventType))
+	assert.Equal(t, probe, broker.retained[publisher.StateTopic(events.ProbeEventType)])
+	var outage OutageEvent
+	require.NoError(t, json.Unmarshal(broker.retained[publisher.StateTopic(OutageEventType)], &outage))
+	assert.Equal(t, "outage-1", outage.ID)
+	assert.Equal(t, Resolved, outage.Status)
+	assert.NotContains(t, broker.retained, publisher.StateTopic(events.BootEventType))
+	assert.Equal(t, "offline", broker.status(t, publisher.StatusTopic()))
+}
+
+func TestMQTTPublisherFailures(t *testing.T) {
+	publisher, broker := newFakeBroker(t)
+	broker.connectErr = errors.New("not authorized")
+	err := publisher.Publish("probe", nil)
+	require.Error(t, err)
+	assert.False(t, IsPermanent(err))
+
+	// The broker never acknowledges the message.
+	broker.connectErr = nil
+	broker.noAcks = true
+	publisher.ackTimeout = time.Millisecond
+	err = publisher.Publish("probe", nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "timed out")
+	assert.Error(t, publisher.Close())
+	assert.False(t, broker.IsConnectionOpen())
+}
+
+func TestMQTTPublisherUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	publisher, err := NewMQTTPublisher(MQTTConfig{Broker: "tcp://" + addr, MonitorID: "monitor-1", AckTimeout: 5 * time.Second})
+	require.NoError(t, err)
+	err = publisher.Publish("probe", nil)
+	require.Error(t, err)
+	assert.False(t, IsPermanent(err))
+	assert.NoError(t, publisher.Close())
+}
+
+func TestNewMQTTPublisherValidatesConfig(t *testing.T) {
+	_, err := NewMQTTPublisher(MQTTConfig{Broker: "tcp://localhost:1883"})
+	assert.Error(t, err)
+	_, err = NewMQTTPublisher(MQTTConfig{Broker: "localhost:1883", MonitorID: "monitor-1"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Sink is a named destination of a MultiPublisher.
+type Sink struct {
+	Name      string
+	Publisher Publisher
+}
+
+// MultiPublisher publishes every message to all of its sinks. A message
+// published again after a partial failure goes to all of them again, so give
+// each sink its own outbox for the messages to be retried per sink.
+type MultiPublisher struct {
+	sinks []Sink
+}
+
+// NewMultiPublisher returns a publisher writing to sinks, which need unique
+// names.
+func NewMultiPublisher(sinks ...Sink) (*MultiPublisher, error) {
+	if len(sinks) == 0 {
+		return nil, errors.New("at least one sink is needed")
+	}
+	names := map[string]bool{}
+	for _, sink := range sinks {
+		if sink.Name == "" || sink.Publisher == nil {
+			return nil, errors.New("sinks need a name and a publisher")
+		}
+		if names[sink.Name] {
+			return nil, fmt.Errorf("duplicate sink %v", sink.Name)
+		}
+		names[sink.Name] = true
+	}
+	return &MultiPublisher{sinks: sinks}, nil
+}
+
+// Sinks returns the sinks of the publisher.
+func (p *MultiPublisher) Sinks() []Sink {
+	return append([]Sink(nil), p.sinks...)
+}
+
+// SinkError is returned when some of the sinks failed. It tells which ones.
+type SinkError struct {
+	Errors map[string]error
+}
+
+func (e *SinkError) Error() string {
+	var failures []string
+	for name, err := range e.Errors {
+		failures = append(failures, fmt.Sprintf("%v: %v", name, err))
+	}
+	sort.Strings(failures)
+	return "sinks failed: " + strings.Join(failures, ", ")
+}
+
+func (p *MultiPublisher) Publish(eventType string, payload []byte) error {
+	return p.publish(func(sink Publisher) error {
+		return sink.Publish(eventType, payload)
+	})
+}
+
+func (p *MultiPublisher) PublishOutageEvent(event OutageEvent) error {
+	return p.publish(func(sink Publisher) error {
+		return sink.PublishOutageEvent(event)
+	})
+}
+
+// publish calls publish on every sink. It returns ErrAlreadyPublished only
+// when all the sinks had the message already.
+func (p *MultiPublisher) publish(publish func(Publisher) error) error {
+	failed := map[string]error{}
+	published := false
+	for _, sink := range p.sinks {
+		err := publish(sink.Publisher)
+		if err != nil && !errors.Is(err, ErrAlreadyPublished) {
+			failed[sink.Name] = err
+			continue
+		}
+		if err == nil {
+			published = true
+		}
+	}
+	if len(failed) > 0 {
+		return &SinkError{Errors: failed}
+	}
+	if !published {
+		return ErrAlreadyPublished
+	}
+	return nil
+}
+
+// Drain drains the sinks that queue messages, such as outboxes. Every sink is
+// drained even if one fails.
+func (p *MultiPublisher) Drain(ctx context.Context) error {
+	failed := map[string]error{}
+	for _, sink := range p.sinks {
+		drainer, ok := sink.Publisher.(interface{ Drain(context.Context) error })
+		if !ok {
+			continue
+		}
+		if err := drainer.Drain(ctx); err != nil {
+			failed[sink.Name] = err
+		}
+	}
+	if len(failed) > 0 {
+		return &SinkError{Errors: failed}
+	}
+	return nil
+}
+
+func (p *MultiPublisher) Close() error {
+	failed := map[string]error{}
+	for _, sink := range p.sinks {
+		if err := sink.Publisher.Close(); err != nil {
+			failed[sink.Name] = err
+		}
+	}
+	if len(failed) > 0 {
+		return &SinkError{Errors: failed}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sinkPublisher records what it publishes, failing while err is set.
+type sinkPublisher struct {
+	err     error
+	events  []string
+	outages []OutageEvent
+	drained int
+	closed  bool
+}
+
+func (p *sinkPublisher) Publish(eventType string, payload []byte) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.events = append(p.events, eventType)
+	return nil
+}
+
+func (p *sinkPublisher) PublishOutageEvent(event OutageEvent) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.outages = append(p.outages, event)
+	return nil
+}
+
+func (p *sinkPublisher) Drain(ctx context.Context) error {
+	p.drained++
+	return nil
+}
+
+func (p *sinkPublisher) Close() error {
+	p.closed = true
+	return nil
+}
+
+func TestMultiPublisherReportsFailedSinks(t *testing.T) {
+	mysql, angostura := &sinkPublisher{}, &sinkPublisher{err: errors.New("timeout")}
+	p, err := NewMultiPublisher(Sink{"mysql", mysql}, Sink{"angostura", angostura})
+	require.NoError(t, err)
+
+	err = p.PublishOutageEvent(OutageEvent{ID: "outage-1", Status: Resolved})
+	var sinkErr *SinkError
+	require.ErrorAs(t, err, &sinkErr)
+	assert.Contains(t, sinkErr.Errors, "angostura")
+	assert.NotContains(t, sinkErr.Errors, "mysql")
+	assert.Len(t, mysql.outages, 1)
+	assert.Empty(t, angostura.outages)
+}
+
+func TestMultiPublisherAlreadyPublished(t *testing.T) {
+	mysql, angostura := &sinkPublisher{err: ErrAlreadyPublished}, &sinkPublisher{}
+	p, err := NewMultiPublisher(Sink{"mysql", mysql}, Sink{"angostura", angostura})
+	require.NoError(t, err)
+
+	require.NoError(t, p.PublishOutageEvent(OutageEvent{ID: "outage-1", Status: Resolved}))
+	angostura.err = ErrAlreadyPublished
+	assert.ErrorIs(t, p.PublishOutageEvent(OutageEvent{ID: "outage-1", Status: Resolved}), ErrAlreadyPublished)
+}
+
+func TestMultiPublisherPublishDrainClose(t *testing.T) {
+	mysql, angostura := &sinkPublisher{}, &sinkPublisher{}
+	p, err := NewMultiPublisher(Sink{"mysql", mysql}, Sink{"angostura", angostura})
+	require.NoError(t, err)
+
+	require.NoError(t, p.Publish("power_outage_probe", []byte("{}")))
+	assert.Equal(t, []string{"power_outage_probe"}, mysql.events)
+	assert.Equal(t, []string{"power_outage_probe"}, angostura.events)
+
+	require.NoError(t, p.Drain(context.Background()))
+	assert.Equal(t, 1, mysql.drained)
+	assert.Equal(t, 1, angostura.drained)
+
+	require.NoError(t, p.Close())
+	assert.True(t, mysql.closed)
+	assert.True(t, angostura.closed)
+}
+
+func TestNewMultiPublisherValidatesSinks(t *testing.T) {
+	_, err := NewMultiPublisher()
+	assert.Error(t, err)
+	_, err = NewMultiPublisher(Sink{"mysql", &sinkPublisher{}}, Sink{"mysql", &sinkPublisher{}})
+	assert.Error(t, err)
+	_, err = NewMultiPublisher(Sink{Name: "mysql"})
+	assert.Error(t, err)
+}
@@ -3,28 +3,76 @@ package store
 import (
 	"database/sql"
 	"fmt"
+	"sync"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
 type MySQLPublisher struct {
 	DB *sql.DB
+
+	// verifySchema is set by NewLazyMySQLPublisher until the schema was
+	// verified.
+	mu           sync.Mutex
+	verifySchema bool
 }
 
-func NewMySQLPublisher(dsn string) (*MySQLPublisher, error) {
+// NewMySQLPublisher connects to the database at dsn. With verifySchema, it
+// fails unless the database was migrated to the schema of this build.
+func NewMySQLPublisher(dsn string, verifySchema bool) (*MySQLPublisher, error) {
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
 		return nil, err
 	}
 
 	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, err
 	}
 
+	if verifySchema {
+		if err := VerifySchema(db); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
 	return &MySQLPublisher{DB: db}, nil
 }
 
+// NewLazyMySQLPublisher returns a publisher to the database at dsn without
+// connecting to it, so a device that starts offline still records outages.
+// It connects, and verifies the schema with verifySchema, on the first
+// message.
+func NewLazyMySQLPublisher(dsn string, verifySchema bool) (*MySQLPublisher, error) {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &MySQLPublisher{DB: db, verifySchema: verifySchema}, nil
+}
+
+// ready verifies the schema the first time it is called after
+// NewLazyMySQLPublisher. A mismatch isn't permanent, the messages are kept
+// until the database is migrated.
+func (p *MySQLPublisher) ready() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.verifySchema {
+		return nil
+	}
+	if err := VerifySchema(p.DB); err != nil {
+		return err
+	}
+	p.verifySchema = false
+	return nil
+}
+
 func (p *MySQLPublisher) Publish(eventType string, payload []byte) error {
+	if err := p.ready(); err != nil {
+		return fmt.Errorf("failed to publish event: %v", err)
+	}
 	_, err := p.DB.Exec(
 		"INSERT INTO Event (event_type, payload) VALUES (?, ?)",
 		eventType, payload,
@@ -35,17 +83,62 @@ func (p *MySQLPublisher) Publish(eventType string, payload []byte) error {
 	return nil
 }
 
+// PublishOutageEvent inserts event, or updates its row if it was published
+// before, e.g. when it started, so publishing an event again is harmless. An
+// event only moves from ongoing to resolved: once resolved, its row is kept as
+// is. Only the device that published the event can update it. It returns
+// ErrAlreadyPublished when the row was left as is, and ErrOtherDevice when it
+// belongs to another device.
 func (p *MySQLPublisher) PublishOutageEvent(event OutageEvent) error {
-	_, err := p.DB.Exec(
-		"INSERT INTO OutageEvent (id, status, start_time, end_time, device_id) VALUES (?, ?, ?, ?, ?)",
-		event.ID, event.Status, event.StartTime, event.EndTime, event.DeviceId,
+	if err := p.ready(); err != nil {
+		return fmt.Errorf("failed to publish outage event: %v", err)
+	}
+	// Ongoing events have no end time.
+	endTime := sql.NullTime{Time: event.EndTime, Valid: event.Status == Resolved}
+	// MySQL assigns from left to right, the other columns have to look at
+	// the status before it is updated. The estimates are only replaced by
+	// newer ones. device_id is never updated.
+	result, err := p.DB.Exec(
+		"INSERT INTO OutageEvent (id, status, start_time, end_time, device_id, estimated_empty_at, device_lost_power_at) "+
+			"VALUES (?, ?, ?, ?, ?, ?, ?) "+
+			"ON DUPLICATE KEY UPDATE "+
+			"estimated_empty_at = IF(status = ? OR device_id <> VALUES(device_id), estimated_empty_at, COALESCE(VALUES(estimated_empty_at), estimated_empty_at)), "+
+			"device_lost_power_at = IF(status = ? OR device_id <> VALUES(device_id), device_lost_power_at, COALESCE(VALUES(device_lost_power_at), device_lost_power_at)), "+
+			"end_time = IF(status = ? OR device_id <> VALUES(device_id), end_time, VALUES(end_time)), "+
+			"status = IF(status = ? OR device_id <> VALUES(device_id), status, VALUES(status))",
+		event.ID, event.Status, event.StartTime, endTime, event.DeviceId,
+		nullTime(event.EstimatedEmptyAt), nullTime(event.DeviceLostPowerAt),
+		Resolved, Resolved, Resolved, Resolved,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to publish outage event: %v", err)
 	}
+	// 1 when inserted, 2 when updated and 0 when nothing changed.
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to publish outage event: %v", err)
+	}
+	if affected == 0 {
+		var owner string
+		err := p.DB.QueryRow("SELECT device_id FROM OutageEvent WHERE id = ?", event.ID).Scan(&owner)
+		if err != nil {
+			return fmt.Errorf("failed to read the device of outage event %v: %v", event.ID, err)
+		}
+		if owner != event.DeviceId {
+			return fmt.Errorf("%w: %v is from %v", ErrOtherDevice, event.ID, owner)
+		}
+		return ErrAlreadyPublished
+	}
 	return nil
 }
 
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: *t, Valid: true}
+}
+
 func (p *MySQLPublisher) Close() error {
 	return p.DB.Close()
 }
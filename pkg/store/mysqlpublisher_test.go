@@ -0,0 +1,155 @@
+package store
+
+import (
+	"database/sql"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const upsertOutageEvent = "INSERT INTO OutageEvent (id, status, start_time, end_time, device_id, estimated_empty_at, device_lost_power_at) " +
+	"VALUES (?, ?, ?, ?, ?, ?, ?) " +
+	"ON DUPLICATE KEY UPDATE " +
+	"estimated_empty_at = IF(status = ? OR device_id <> VALUES(device_id), estimated_empty_at, COALESCE(VALUES(estimated_empty_at), estimated_empty_at)), " +
+	"device_lost_power_at = IF(status = ? OR device_id <> VALUES(device_id), device_lost_power_at, COALESCE(VALUES(device_lost_power_at), device_lost_power_at)), " +
+	"end_time = IF(status = ? OR device_id <> VALUES(device_id), end_time, VALUES(end_time)), " +
+	"status = IF(status = ? OR device_id <> VALUES(device_id), status, VALUES(status))"
+
+const selectOutageEventOwner = "SELECT device_id FROM OutageEvent WHERE id = ?"
+
+func newMockPublisher(t *testing.T) (*MySQLPublisher, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return &MySQLPublisher{DB: db}, mock
+}
+
+func TestMySQLPublisherUpsertsOutageEvent(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	emptyAt := start.Add(40 * time.Minute)
+	lostPowerAt := start.Add(45 * time.Minute)
+
+	for _, tc := range []struct {
+		name     string
+		event    OutageEvent
+		endTime  sql.NullTime
+		emptyAt  sql.NullTime
+		lostAt   sql.NullTime
+		affected int64
+		// owner is the device of the row left as is.
+		owner string
+		err   error
+	}{
+		{
+			name:     "started",
+			event:    OutageEvent{ID: "outage-1", Status: Ongoing, StartTime: start, DeviceId: "monitor-1"},
+			affected: 1,
+		},
+		{
+			name: "estimated",
+			event: OutageEvent{
+				ID: "outage-1", Status: Ongoing, StartTime: start, DeviceId: "monitor-1",
+				EstimatedEmptyAt: &emptyAt,
+			},
+			emptyAt:  sql.NullTime{Time: emptyAt, Valid: true},
+			affected: 2,
+		},
+		{
+			name:     "ended",
+			event:    OutageEvent{ID: "outage-1", Status: Resolved, StartTime: start, EndTime: end, DeviceId: "monitor-1"},
+			endTime:  sql.NullTime{Time: end, Valid: true},
+			affected: 2,
+		},
+		{
+			name: "outlasted the battery",
+			event: OutageEvent{
+				ID: "outage-1", Status: Resolved, StartTime: start, EndTime: end, DeviceId: "monitor-1",
+				EstimatedEmptyAt: &emptyAt, DeviceLostPowerAt: &lostPowerAt,
+			},
+			endTime:  sql.NullTime{Time: end, Valid: true},
+			emptyAt:  sql.NullTime{Time: emptyAt, Valid: true},
+			lostAt:   sql.NullTime{Time: lostPowerAt, Valid: true},
+			affected: 1,
+		},
+		{
+			name:     "published again",
+			event:    OutageEvent{ID: "outage-1", Status: Resolved, StartTime: start, EndTime: end, DeviceId: "monitor-1"},
+			endTime:  sql.NullTime{Time: end, Valid: true},
+			affected: 0,
+			owner:    "monitor-1",
+			err:      ErrAlreadyPublished,
+		},
+		{
+			name:     "resolved by another device",
+			event:    OutageEvent{ID: "outage-1", Status: Resolved, StartTime: start, EndTime: end, DeviceId: "monitor-1"},
+			endTime:  sql.NullTime{Time: end, Valid: true},
+			affected: 0,
+			owner:    "monitor-2",
+			err:      ErrOtherDevice,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, mock := newMockPublisher(t)
+			mock.ExpectExec(regexp.QuoteMeta(upsertOutageEvent)).
+				WithArgs(
+					tc.event.ID, tc.event.Status, start, tc.endTime, "monitor-1", tc.emptyAt, tc.lostAt,
+					Resolved, Resolved, Resolved, Resolved,
+				).
+				WillReturnResult(sqlmock.NewResult(0, tc.affected))
+			if tc.owner != "" {
+				mock.ExpectQuery(regexp.QuoteMeta(selectOutageEventOwner)).
+					WithArgs(tc.event.ID).
+					WillReturnRows(sqlmock.NewRows([]string{"device_id"}).AddRow(tc.owner))
+			}
+
+			err := p.PublishOutageEvent(tc.event)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestMySQLPublisherOutageEventError(t *testing.T) {
+	p, mock := newMockPublisher(t)
+	mock.ExpectExec("INSERT INTO OutageEvent").WillReturnError(sql.ErrConnDone)
+	err := p.PublishOutageEvent(OutageEvent{ID: "outage-1", Status: Ongoing})
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrAlreadyPublished)
+}
+
+func TestMySQLPublisherVerifiesSchemaOnFirstMessage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	p := &MySQLPublisher{DB: db, verifySchema: true}
+
+	// The device is offline.
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT MAX(version) FROM schema_version")).WillReturnError(sql.ErrConnDone)
+	err = p.Publish("power_outage_probe", []byte("{}"))
+	require.Error(t, err)
+	assert.False(t, IsPermanent(err))
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT MAX(version) FROM schema_version")).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(LatestSchemaVersion()))
+	mock.ExpectExec("INSERT INTO Event").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO Event").WillReturnResult(sqlmock.NewResult(2, 1))
+	require.NoError(t, p.Publish("power_outage_probe", []byte("{}")))
+	require.NoError(t, p.Publish("power_outage_probe", []byte("{}")))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestNewLazyMySQLPublisherDoesNotConnect(t *testing.T) {
+	p, err := NewLazyMySQLPublisher("user:password@tcp(127.0.0.1:1)/poweroutage", true)
+	require.NoError(t, err)
+	defer p.Close()
+	assert.Error(t, p.Publish("power_outage_probe", []byte("{}")))
+}
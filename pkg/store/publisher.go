@@ -1,7 +1,72 @@
 package store
 
+import (
+	"errors"
+
+	"github.com/code-for-venezuela/poweroutage/pkg/events"
+)
+
+// OutageEventType is the event type of the outage events sent by the
+// publishers that, unlike MySQL, have no table of their own for them.
+const OutageEventType = events.OutageEventType
+
 type Publisher interface {
 	Publish(eventType string, payload []byte) error
 	PublishOutageEvent(event OutageEvent) error
 	Close() error
 }
+
+// ErrAlreadyPublished is returned by PublishOutageEvent when the event was
+// published before and publishing it again changed nothing, e.g. when a sync
+// was interrupted before the event file was deleted. Callers treat it as a
+// success.
+var ErrAlreadyPublished = errors.New("outage event already published")
+
+// ErrOtherDevice is returned by PublishOutageEvent when the event was
+// published before by another device, which alone can update it.
+var ErrOtherDevice = errors.New("outage event belongs to another device")
+
+// PermanentError is returned when publishing a message again won't help,
+// e.g. because the destination rejected it as invalid. Any other error is
+// assumed to be temporary, such as the network being down.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent marks err as permanent.
+func Permanent(err error) error {
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent reports whether err, or an error it wraps, is permanent.
+func IsPermanent(err error) bool {
+	var permanent *PermanentError
+	return errors.As(err, &permanent)
+}
+
+// ErrPublishingDisabled is returned by DisabledPublisher.
+var ErrPublishingDisabled = errors.New("publishing is disabled")
+
+// DisabledPublisher is used in place of the real publisher when publishing is
+// turned off. Events are kept on disk until it is turned on again.
+type DisabledPublisher struct{}
+
+func (DisabledPublisher) Publish(eventType string, payload []byte) error {
+	return ErrPublishingDisabled
+}
+
+func (DisabledPublisher) PublishOutageEvent(event OutageEvent) error {
+	return ErrPublishingDisabled
+}
+
+func (DisabledPublisher) Close() error {
+	return nil
+}
@@ -0,0 +1,262 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/code-for-venezuela/poweroutage/pkg/events"
+	"github.com/pusher/pusher-http-go/v5"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Events triggered on the Pusher channels.
+const (
+	PusherOutageStartEvent = "outage-start"
+	// PusherOutageUpdateEvent is triggered when an outage that started is
+	// published again, e.g. with a new time to empty estimate.
+	PusherOutageUpdateEvent = "outage-update"
+	PusherOutageEndEvent    = "outage-end"
+	PusherProbeEvent        = "probe"
+)
+
+// Limits of the Pusher channels API.
+const (
+	// MaxPusherEventData is the largest data of an event, in bytes.
+	MaxPusherEventData = 10240
+	// maxPusherChannelName is the longest channel name.
+	maxPusherChannelName = 164
+)
+
+// pusherEvents maps the event types published to the Pusher events. The
+// rest of the types don't matter to the live map and aren't sent.
+var pusherEvents = map[string]string{
+	events.ProbeEventType: PusherProbeEvent,
+}
+
+// PusherConfig holds the settings of the Pusher publisher.
+type PusherConfig struct {
+	AppID   string
+	Key     string
+	Secret  string
+	Cluster string
+	// Endpoint is the scheme and host of the API,
+	// https://api-<Cluster>.pusher.com when empty. It can't have a path.
+	Endpoint string
+	// Timeout bounds a whole request, 10 seconds when zero.
+	Timeout time.Duration
+	// ChannelPrefix starts the channel names, poweroutage when empty.
+	ChannelPrefix string
+
+	// The location of the device, which names the parish channel.
+	State        string
+	Municipality string
+	Parish       string
+	MonitorID    string
+}
+
+// PusherPublisher triggers outage and probe events on the channel of the
+// parish of the device, <prefix>-parish-<state>-<municipality>-<parish>, and on
+// the channel of the device, <prefix>-device-<monitor ID>, so live maps are
+// updated as they happen.
+type PusherPublisher struct {
+	channels []string
+	client   *pusher.Client
+
+	// started is the ongoing outage whose outage-start was triggered. After
+	// a restart the monitor publishes the ongoing outage again, which
+	// triggers outage-start again.
+	mu      sync.Mutex
+	started string
+}
+
+// NewPusherPublisher returns a publisher to the Pusher app in config.
+func NewPusherPublisher(config PusherConfig) (*PusherPublisher, error) {
+	if config.AppID == "" || config.Key == "" || config.Secret == "" {
+		return nil, errors.New("the Pusher publisher needs an app ID, key and secret")
+	}
+	if config.State == "" || config.Municipality == "" || config.Parish == "" || config.MonitorID == "" {
+		return nil, errors.New("the Pusher publisher needs the state, municipality, parish and monitor ID")
+	}
+	if config.Endpoint == "" {
+		if config.Cluster == "" {
+			return nil, errors.New("the Pusher publisher needs a cluster")
+		}
+		config.Endpoint = "https://api-" + config.Cluster + ".pusher.com"
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.ChannelPrefix == "" {
+		config.ChannelPrefix = "poweroutage"
+	}
+	channels := []string{
+		PusherChannel(config.ChannelPrefix, "parish", config.State, config.Municipality, config.Parish),
+		PusherChannel(config.ChannelPrefix, "device", config.MonitorID),
+	}
+	for _, channel := range channels {
+		if len(channel) > maxPusherChannelName {
+			return nil, fmt.Errorf("Pusher channel %v is longer than %v characters", channel, maxPusherChannelName)
+		}
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(config.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid Pusher endpoint %q", config.Endpoint)
+	}
+	// The client only takes a host, a path would be ignored.
+	if endpoint.Path != "" || endpoint.RawQuery != "" {
+		return nil, fmt.Errorf("the Pusher endpoint %q can't have a path", config.Endpoint)
+	}
+	return &PusherPublisher{
+		channels: channels,
+		client: &pusher.Client{
+			AppID:      config.AppID,
+			Key:        config.Key,
+			Secret:     config.Secret,
+			Host:       endpoint.Host,
+			Secure:     endpoint.Scheme == "https",
+			HTTPClient: newHTTPClient(config.Timeout),
+		},
+	}, nil
+}
+
+// channelNameReplacer drops the accents of the Spanish place names and
+// replaces spaces.
+var channelNameReplacer = strings.NewReplacer(
+	"á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u", "ü", "u", "ñ", "n", " ", "_",
+)
+
+// PusherChannel joins parts into a channel name, lowercased and with the
+// characters Pusher doesn't allow replaced by underscores.
+func PusherChannel(parts ...string) string {
+	name := channelNameReplacer.Replace(strings.ToLower(strings.Join(parts, "-")))
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', strings.ContainsRune("_-=@,.;", r):
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// Channels returns the channels the events are triggered on.
+func (p *PusherPublisher) Channels() []string {
+	return append([]string(nil), p.channels...)
+}
+
+// Publish triggers a probe event with payload as its data. Other event types
+// are skipped.
+func (p *PusherPublisher) Publish(eventType string, payload []byte) error {
+	name, ok := pusherEvents[eventType]
+	if !ok {
+		log.Debugf("not sending %v events to Pusher", eventType)
+		return nil
+	}
+	return p.trigger(name, payload)
+}
+
+// PublishOutageEvent triggers outage-start for an ongoing event,
+// outage-update when it is published again and outage-end for a resolved one.
+func (p *PusherPublisher) PublishOutageEvent(event OutageEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	name := PusherOutageStartEvent
+	switch {
+	case event.Status == Resolved:
+		name = PusherOutageEndEvent
+	case event.ID == p.started:
+		name = PusherOutageUpdateEvent
+	}
+	if err := p.trigger(name, payload); err != nil {
+		return err
+	}
+	if event.Status == Resolved {
+		p.started = ""
+	} else {
+		p.started = event.ID
+	}
+	return nil
+}
+
+// trigger sends event with data to the channels. It returns a PermanentError
+// when Pusher rejects the event.
+func (p *PusherPublisher) trigger(event string, data []byte) error {
+	data = fitPusherData(event, data)
+	if err := p.client.TriggerMulti(p.channels, event, data); err != nil {
+		return pusherError(err)
+	}
+	return nil
+}
+
+// fitPusherData trims data to the MaxPusherEventData bytes Pusher accepts, so
+// the live maps still hear about the event. The largest fields of the JSON
+// object are dropped first, and truncated is set.
+func fitPusherData(event string, data []byte) []byte {
+	if len(data) <= MaxPusherEventData {
+		return data
+	}
+	log.Warnf("trimming %v event of %v bytes to the %v bytes Pusher accepts", event, len(data), MaxPusherEventData)
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		fields = map[string]json.RawMessage{}
+	}
+	fields["truncated"] = json.RawMessage("true")
+	for {
+		trimmed, err := json.Marshal(fields)
+		if err == nil && len(trimmed) <= MaxPusherEventData {
+			return trimmed
+		}
+		largest := ""
+		for name, value := range fields {
+			if name != "truncated" && (largest == "" || len(value) > len(fields[largest])) {
+				largest = name
+			}
+		}
+		delete(fields, largest)
+	}
+}
+
+// pusherStatus matches the errors of the Pusher client for the responses
+// other than 2xx.
+var pusherStatus = regexp.MustCompile(`^Status Code: (\d+) - `)
+
+// pusherError tells apart the events Pusher rejected, which are permanent,
+// from the failures worth retrying.
+func pusherError(err error) error {
+	match := pusherStatus.FindStringSubmatch(err.Error())
+	if match == nil {
+		// The error of the HTTP client repeats the URL, with the signature.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("error triggering Pusher event: %v", err)
+	}
+	status, _ := strconv.Atoi(match[1])
+	body := strings.TrimSpace(strings.TrimPrefix(err.Error(), match[0]))
+	if len(body) > maxErrorBody {
+		body = body[:maxErrorBody]
+	}
+	httpErr := &HTTPError{StatusCode: status, Body: body}
+	if retryableStatus(status) {
+		return fmt.Errorf("error triggering Pusher event: %w", httpErr)
+	}
+	return Permanent(fmt.Errorf("Pusher rejected the event: %w", httpErr))
+}
+
+func (p *PusherPublisher) Close() error {
+	p.client.HTTPClient.CloseIdleConnections()
+	return nil
+}
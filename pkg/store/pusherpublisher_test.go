@@ -0,0 +1,190 @@
+package store
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testPusherConfig = PusherConfig{
+	AppID:        "3",
+	Key:          "278d425bdf160c739803",
+	Secret:       "7ad3773142a6692b25b8",
+	State:        "Mérida",
+	Municipality: "Libertador",
+	Parish:       "El Llano",
+	MonitorID:    "monitor-1",
+}
+
+type pusherTrigger struct {
+	Name     string   `json:"name"`
+	Channels []string `json:"channels"`
+	Data     string   `json:"data"`
+}
+
+// pusherServer stands in for the Pusher REST API. It checks the
+// authentication of the requests and records the events triggered.
+func pusherServer(t *testing.T, status int, triggers *[]pusherTrigger) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/apps/3/events", r.URL.Path)
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		query := r.URL.Query()
+		bodyMD5 := md5.Sum(body)
+		assert.Equal(t, hex.EncodeToString(bodyMD5[:]), query.Get("body_md5"))
+		assert.Equal(t, testPusherConfig.Key, query.Get("auth_key"))
+		var params []string
+		for key := range query {
+			if key != "auth_signature" {
+				params = append(params, key+"="+query.Get(key))
+			}
+		}
+		sort.Strings(params)
+		mac := hmac.New(sha256.New, []byte(testPusherConfig.Secret))
+		mac.Write([]byte("POST\n/apps/3/events\n" + strings.Join(params, "&")))
+		assert.Equal(t, hex.EncodeToString(mac.Sum(nil)), query.Get("auth_signature"))
+
+		var trigger pusherTrigger
+		require.NoError(t, json.Unmarshal(body, &trigger))
+		*triggers = append(*triggers, trigger)
+		w.WriteHeader(status)
+		io.WriteString(w, "{}")
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestPusherPublisher(t *testing.T, endpoint string) *PusherPublisher {
+	config := testPusherConfig
+	config.Endpoint = endpoint
+	publisher, err := NewPusherPublisher(config)
+	require.NoError(t, err)
+	t.Cleanup(func() { publisher.Close() })
+	return publisher
+}
+
+func TestPusherTriggersEvents(t *testing.T) {
+	var triggers []pusherTrigger
+	server := pusherServer(t, http.StatusOK, &triggers)
+	publisher := newTestPusherPublisher(t, server.URL)
+
+	start := OutageEvent{ID: "outage-1", Status: Ongoing, DeviceId: "monitor-1"}
+	require.NoError(t, publisher.PublishOutageEvent(start))
+	update := start
+	emptyAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	update.EstimatedEmptyAt = &emptyAt
+	require.NoError(t, publisher.PublishOutageEvent(update))
+	end := start
+	end.Status = Resolved
+	require.NoError(t, publisher.PublishOutageEvent(end))
+	require.NoError(t, publisher.Publish("power_outage_probe", []byte(`{"status":"healthy"}`)))
+	require.NoError(t, publisher.Publish("power_outage_boot", []byte(`{}`)))
+
+	require.Len(t, triggers, 4)
+	channels := []string{"poweroutage-parish-merida-libertador-el_llano", "poweroutage-device-monitor-1"}
+	for _, trigger := range triggers {
+		assert.Equal(t, channels, trigger.Channels)
+	}
+	assert.Equal(t, PusherOutageStartEvent, triggers[0].Name)
+	assert.Equal(t, PusherOutageUpdateEvent, triggers[1].Name)
+	assert.Equal(t, PusherOutageEndEvent, triggers[2].Name)
+	assert.Equal(t, PusherProbeEvent, triggers[3].Name)
+	assert.Equal(t, `{"status":"healthy"}`, triggers[3].Data)
+
+	var event OutageEvent
+	require.NoError(t, json.Unmarshal([]byte(triggers[1].Data), &event))
+	require.NotNil(t, event.EstimatedEmptyAt)
+	assert.True(t, emptyAt.Equal(*event.EstimatedEmptyAt))
+	require.NoError(t, json.Unmarshal([]byte(triggers[2].Data), &event))
+	assert.Equal(t, end.ID, event.ID)
+	assert.Equal(t, Resolved, event.Status)
+}
+
+func TestPusherStartsOutageAgainAfterFailure(t *testing.T) {
+	var triggers []pusherTrigger
+	down := pusherServer(t, http.StatusServiceUnavailable, &triggers)
+	publisher := newTestPusherPublisher(t, down.URL)
+	start := OutageEvent{ID: "outage-1", Status: Ongoing, DeviceId: "monitor-1"}
+	require.Error(t, publisher.PublishOutageEvent(start))
+
+	// The outbox sends it again, the live maps haven't heard of it yet.
+	up := pusherServer(t, http.StatusOK, &triggers)
+	publisher.client.Host = strings.TrimPrefix(up.URL, "http://")
+	require.NoError(t, publisher.PublishOutageEvent(start))
+	require.Len(t, triggers, 2)
+	assert.Equal(t, PusherOutageStartEvent, triggers[1].Name)
+}
+
+func TestPusherErrors(t *testing.T) {
+	tests := []struct {
+		status    int
+		permanent bool
+	}{
+		{http.StatusInternalServerError, false},
+		{http.StatusUnauthorized, false},
+		{http.StatusBadRequest, true},
+		{http.StatusRequestEntityTooLarge, true},
+	}
+	for _, test := range tests {
+		t.Run(http.StatusText(test.status), func(t *testing.T) {
+			var triggers []pusherTrigger
+			server := pusherServer(t, test.status, &triggers)
+			err := newTestPusherPublisher(t, server.URL).Publish("power_outage_probe", []byte("{}"))
+			require.Error(t, err)
+			assert.Equal(t, test.permanent, IsPermanent(err))
+		})
+	}
+}
+
+func TestPusherSizeLimit(t *testing.T) {
+	var triggers []pusherTrigger
+	server := pusherServer(t, http.StatusOK, &triggers)
+	publisher := newTestPusherPublisher(t, server.URL)
+
+	payload, err := json.Marshal(map[string]string{"status": "healthy", "padding": strings.Repeat("x", MaxPusherEventData)})
+	require.NoError(t, err)
+	require.NoError(t, publisher.Publish("power_outage_probe", payload))
+	require.Len(t, triggers, 1)
+	assert.JSONEq(t, `{"status":"healthy","truncated":true}`, triggers[0].Data)
+
+	require.NoError(t, publisher.Publish("power_outage_probe", []byte(strings.Repeat("x", MaxPusherEventData+1))))
+	require.Len(t, triggers, 2)
+	assert.JSONEq(t, `{"truncated":true}`, triggers[1].Data)
+}
+
+func TestNewPusherPublisher(t *testing.T) {
+	config := testPusherConfig
+	config.Cluster = "us2"
+	publisher, err := NewPusherPublisher(config)
+	require.NoError(t, err)
+	assert.Equal(t, "api-us2.pusher.com", publisher.client.Host)
+	assert.True(t, publisher.client.Secure)
+
+	for _, change := range []func(*PusherConfig){
+		func(c *PusherConfig) { c.Cluster = "" },
+		func(c *PusherConfig) { c.Secret = "" },
+		func(c *PusherConfig) { c.Parish = "" },
+		func(c *PusherConfig) { c.Endpoint = "api.pusher.com" },
+		func(c *PusherConfig) { c.Endpoint = "https://proxy.example.com/pusher" },
+		func(c *PusherConfig) { c.MonitorID = strings.Repeat("m", maxPusherChannelName) },
+	} {
+		invalid := config
+		change(&invalid)
+		_, err := NewPusherPublisher(invalid)
+		assert.Error(t, err)
+	}
+}
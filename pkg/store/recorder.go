@@ -59,11 +59,22 @@ type OutageEvent struct {
 	EndTime   time.Time `json:"end_time"`
 	DeviceId  string    `json:"device_id"`
 	ID        string    `json:"id"`
+	// EstimatedEmptyAt is when the battery is expected to run out while the
+	// outage is ongoing.
+	EstimatedEmptyAt *time.Time `json:"estimated_empty_at,omitempty"`
+	// DeviceLostPowerAt is set when the device ran out of battery during the
+	// outage. The outage ended at some point between then and EndTime, when
+	// the device booted again.
+	DeviceLostPowerAt *time.Time `json:"device_lost_power_at,omitempty"`
 }
 
 type OutageRecorder interface {
-	StartIncident() (*OutageEvent, error)
-	FinishIncident() error
+	// StartIncident records an incident that started at at.
+	StartIncident(at time.Time) (*OutageEvent, error)
+	UpdateIncident(event OutageEvent) error
+	// FinishIncident resolves the ongoing incident, ended at at, and
+	// returns it.
+	FinishIncident(at time.Time) (*OutageEvent, error)
 	GetMostRecentEvent() (*OutageEvent, error)
 	GetFinishedEvents() ([]string, []OutageEvent, error)
 	DeleteEventFile(eventFile string) error
@@ -73,20 +84,90 @@ type fileSystemRecorder struct {
 	locationID string
 	eventsDir  string
 	finishDir  string
+	fs         fileSystem
 }
 
+// NewFileSystemRecorder stores events as JSON files, the ongoing one in
+// eventsDir and the finished ones in finishDir. Files are written atomically
+// and synced, so a power cut never leaves a torn event behind, and the
+// leftovers of an interrupted write are cleaned up when the recorder is
+// created.
 func NewFileSystemRecorder(locationID string, eventsDir string, finishDir string) (OutageRecorder, error) {
-	r := &fileSystemRecorder{locationID: locationID, eventsDir: eventsDir, finishDir: finishDir}
+	return newFileSystemRecorder(locationID, eventsDir, finishDir, osFileSystem{})
+}
+
+func newFileSystemRecorder(locationID string, eventsDir string, finishDir string, fs fileSystem) (*fileSystemRecorder, error) {
+	r := &fileSystemRecorder{locationID: locationID, eventsDir: eventsDir, finishDir: finishDir, fs: fs}
 	if err := r.createEventsDirIfNotExists(); err != nil {
 		return nil, fmt.Errorf("error creating events directory: %v", err)
 	}
+	if err := r.recover(); err != nil {
+		return nil, fmt.Errorf("error recovering events: %v", err)
+	}
 	return r, nil
 }
 
-func (r *fileSystemRecorder) StartIncident() (*OutageEvent, error) {
+// recover removes the temporary files of interrupted writes and finishes
+// moving resolved events that were not moved to finishDir yet.
+func (r *fileSystemRecorder) recover() error {
+	for _, dir := range []string{r.eventsDir, r.finishDir} {
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if !isTempFile(file.Name()) {
+				continue
+			}
+			log.Warnf("Removing leftover of an interrupted write: %v", filepath.Join(dir, file.Name()))
+			if err := r.fs.Remove(filepath.Join(dir, file.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	files, err := eventFiles(r.eventsDir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		eventBytes, err := os.ReadFile(filepath.Join(r.eventsDir, file))
+		if err != nil {
+			return err
+		}
+		var event OutageEvent
+		if err := json.Unmarshal(eventBytes, &event); err != nil || event.Status != Resolved {
+			continue
+		}
+		log.Infof("Moving resolved event %v to the finished events", event.ID)
+		if err := r.moveEventFileToFinishedDir(&event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eventFiles lists the event files in dir, skipping anything else such as
+// temporary files.
+func eventFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		files = append(files, entry.Name())
+	}
+	return files, nil
+}
+
+func (r *fileSystemRecorder) StartIncident(at time.Time) (*OutageEvent, error) {
 	event := OutageEvent{
 		Status:    Ongoing,
-		StartTime: time.Now(),
+		StartTime: at,
 		DeviceId:  r.locationID,
 		ID:        uuid.New().String(),
 	}
@@ -97,28 +178,41 @@ func (r *fileSystemRecorder) StartIncident() (*OutageEvent, error) {
 	return &event, nil
 }
 
-func (r *fileSystemRecorder) FinishIncident() error {
-	event, err := r.GetMostRecentEvent()
+// UpdateIncident overwrites the ongoing incident with event, e.g. to record a
+// new time to empty estimate.
+func (r *fileSystemRecorder) UpdateIncident(event OutageEvent) error {
+	current, err := r.GetMostRecentEvent()
 	if err != nil {
 		return fmt.Errorf("error getting most recent event: %v", err)
 	}
+	if current.ID != event.ID || current.Status != Ongoing {
+		return fmt.Errorf("event %v is not the ongoing incident", event.ID)
+	}
+	return r.writeEventToFile(event)
+}
+
+func (r *fileSystemRecorder) FinishIncident(at time.Time) (*OutageEvent, error) {
+	event, err := r.GetMostRecentEvent()
+	if err != nil {
+		return nil, fmt.Errorf("error getting most recent event: %v", err)
+	}
 	if event.Status != Ongoing {
-		return fmt.Errorf("cannot finish incident with status %v", event.Status)
+		return nil, fmt.Errorf("cannot finish incident with status %v", event.Status)
 	}
 	event.Status = Resolved
-	event.EndTime = time.Now()
+	event.EndTime = at
 
 	// Write updated event to the original file
 	if err := r.writeEventToFile(*event); err != nil {
-		return err
+		return nil, err
 	}
 
 	// Move the file to the finished events folder
 	if err := r.moveEventFileToFinishedDir(event); err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return event, nil
 }
 
 func (r *fileSystemRecorder) moveEventFileToFinishedDir(event *OutageEvent) error {
@@ -134,7 +228,7 @@ func (r *fileSystemRecorder) moveEventFileToFinishedDir(event *OutageEvent) erro
 	finishedFilename := getEventFilename(event.DeviceId, event.StartTime)
 	finishedFilePath := filepath.Join(r.finishDir, finishedFilename)
 
-	if err := os.Rename(eventFilePath, finishedFilePath); err != nil {
+	if err := renameDurable(r.fs, eventFilePath, finishedFilePath); err != nil {
 		return err
 	}
 
@@ -146,9 +240,11 @@ func getEventFilename(locationID string, startTime time.Time) string {
 }
 
 func (r *fileSystemRecorder) createEventsDirIfNotExists() error {
-	if _, err := os.Stat(r.eventsDir); os.IsNotExist(err) {
-		if err := os.Mkdir(r.eventsDir, 0755); err != nil {
-			return err
+	for _, dir := range []string{r.eventsDir, r.finishDir} {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			if err := os.Mkdir(dir, 0755); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -167,10 +263,7 @@ func (r *fileSystemRecorder) GetFinishedEvents() ([]string, []OutageEvent, error
 	if err != nil {
 		return nil, nil, fmt.Errorf("error getting events directory: %v", err)
 	}
-	files, err := os.ReadDir(dir)
-	if err != nil {
-		return nil, nil, fmt.Errorf("error reading events directory: %v", err)
-	}
+	files, err := eventFiles(dir)
 	if err != nil {
 		return nil, nil, errors.Wrapf(err, "error reading finished events")
 	}
@@ -178,7 +271,7 @@ func (r *fileSystemRecorder) GetFinishedEvents() ([]string, []OutageEvent, error
 	events := make([]OutageEvent, len(files))
 	fileNames := make([]string, len(files))
 	for i, file := range files {
-		eventBytes, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		eventBytes, err := os.ReadFile(filepath.Join(dir, file))
 		if err != nil {
 			return nil, nil, fmt.Errorf("error reading event file: %v", err)
 		}
@@ -189,7 +282,7 @@ func (r *fileSystemRecorder) GetFinishedEvents() ([]string, []OutageEvent, error
 		}
 
 		events[i] = event
-		fileNames[i] = file.Name()
+		fileNames[i] = file
 	}
 
 	return fileNames, events, nil
@@ -213,7 +306,7 @@ func (r *fileSystemRecorder) GetMostRecentEvent() (*OutageEvent, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error getting events directory: %v", err)
 	}
-	files, err := os.ReadDir(dir)
+	files, err := eventFiles(dir)
 	if err != nil {
 		return nil, fmt.Errorf("error reading events directory: %v", err)
 	}
@@ -221,19 +314,21 @@ func (r *fileSystemRecorder) GetMostRecentEvent() (*OutageEvent, error) {
 		return nil, fmt.Errorf("no outage events recorded")
 	}
 	file := files[0]
-	eventBytes, err := os.ReadFile(filepath.Join(dir, file.Name()))
+	eventBytes, err := os.ReadFile(filepath.Join(dir, file))
 	if err != nil {
 		return nil, fmt.Errorf("error reading event file: %v", err)
 	}
 	var event OutageEvent
 	if err := json.Unmarshal(eventBytes, &event); err != nil {
-		filePath := filepath.Join(dir, file.Name())
-		deleteFileErr := os.Remove(filePath)
-		if deleteFileErr != nil {
-			log.Errorf("Error deleting bad event file: %v", deleteFileErr)
+		// Keep the file around for inspection, but out of the way of new
+		// events.
+		filePath := filepath.Join(dir, file)
+		renameErr := r.fs.Rename(filePath, filePath+".corrupt")
+		if renameErr != nil {
+			log.Errorf("Error moving bad event file: %v", renameErr)
 		} else {
-			log.Infof(
-				"Deleted event file that had bad schema: %v. This was the event: %v",
+			log.Errorf(
+				"Moved event file that had bad schema: %v. This was the event: %v",
 				filePath,
 				string(eventBytes),
 			)
@@ -253,7 +348,7 @@ func (r *fileSystemRecorder) writeEventToFile(event OutageEvent) error {
 	if err != nil {
 		return fmt.Errorf("error getting events directory: %v", err)
 	}
-	if err := os.WriteFile(filepath.Join(dir, filename), eventBytes, 0644); err != nil {
+	if err := writeFileAtomic(r.fs, filepath.Join(dir, filename), eventBytes, 0644); err != nil {
 		return fmt.Errorf("error writing event file: %v", err)
 	}
 	return nil
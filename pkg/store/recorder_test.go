@@ -0,0 +1,260 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errPowerCut = errors.New("power cut")
+
+// crashingFS simulates the device losing power at one step of a write. Every
+// operation from that step on fails, so nothing is cleaned up, and a torn
+// write leaves half of the data in the temporary file.
+type crashingFS struct {
+	osFileSystem
+	step    string
+	crashed bool
+}
+
+func (fs *crashingFS) crash(step string) bool {
+	if step == fs.step {
+		fs.crashed = true
+	}
+	return fs.crashed
+}
+
+func (fs *crashingFS) CreateTemp(dir, pattern string) (tempFile, error) {
+	if fs.crash("create") {
+		return nil, errPowerCut
+	}
+	f, err := fs.osFileSystem.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &crashingFile{tempFile: f, fs: fs}, nil
+}
+
+func (fs *crashingFS) Rename(oldpath, newpath string) error {
+	if fs.crash("rename") {
+		return errPowerCut
+	}
+	return fs.osFileSystem.Rename(oldpath, newpath)
+}
+
+func (fs *crashingFS) Remove(name string) error {
+	if fs.crashed {
+		return errPowerCut
+	}
+	return fs.osFileSystem.Remove(name)
+}
+
+func (fs *crashingFS) SyncDir(dir string) error {
+	if fs.crash("syncdir") {
+		return errPowerCut
+	}
+	return fs.osFileSystem.SyncDir(dir)
+}
+
+type crashingFile struct {
+	tempFile
+	fs *crashingFS
+}
+
+func (f *crashingFile) Write(b []byte) (int, error) {
+	if f.fs.crash("write") {
+		n, _ := f.tempFile.Write(b[:len(b)/2])
+		return n, errPowerCut
+	}
+	return f.tempFile.Write(b)
+}
+
+func (f *crashingFile) Sync() error {
+	if f.fs.crash("sync") {
+		return errPowerCut
+	}
+	return f.tempFile.Sync()
+}
+
+func (f *crashingFile) Close() error {
+	// The descriptor is released either way, like when the process dies.
+	err := f.tempFile.Close()
+	if f.fs.crash("close") {
+		return errPowerCut
+	}
+	return err
+}
+
+type recorderDirs struct {
+	events, finished string
+}
+
+func newRecorderDirs(t *testing.T) recorderDirs {
+	dir := t.TempDir()
+	return recorderDirs{filepath.Join(dir, "events"), filepath.Join(dir, "finished")}
+}
+
+// open creates a recorder over the directories, like the monitor does when
+// the device boots.
+func (d recorderDirs) open(t *testing.T, fs fileSystem) *fileSystemRecorder {
+	r, err := newFileSystemRecorder("test", d.events, d.finished, fs)
+	require.NoError(t, err)
+	return r
+}
+
+func (d recorderDirs) files(t *testing.T) []string {
+	var files []string
+	for _, dir := range []string{d.events, d.finished} {
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		for _, entry := range entries {
+			files = append(files, filepath.Join(filepath.Base(dir), entry.Name()))
+		}
+	}
+	return files
+}
+
+var steps = []string{"create", "write", "sync", "close", "rename", "syncdir"}
+
+// committed reports whether the write reached the disk when the power was cut
+// at step.
+func committed(step string) bool {
+	return step == "syncdir"
+}
+
+func TestRecorderLifecycle(t *testing.T) {
+	dirs := newRecorderDirs(t)
+	r := dirs.open(t, osFileSystem{})
+
+	_, err := r.GetMostRecentEvent()
+	assert.EqualError(t, err, "no outage events recorded")
+
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	event, err := r.StartIncident(start)
+	require.NoError(t, err)
+	assert.Equal(t, start, event.StartTime)
+	emptyAt := event.StartTime.Add(time.Hour)
+	event.EstimatedEmptyAt = &emptyAt
+	require.NoError(t, r.UpdateIncident(*event))
+
+	ongoing, err := r.GetMostRecentEvent()
+	require.NoError(t, err)
+	assert.Equal(t, event.ID, ongoing.ID)
+	assert.Equal(t, Ongoing, ongoing.Status)
+	assert.True(t, emptyAt.Equal(*ongoing.EstimatedEmptyAt))
+
+	finished, err := r.FinishIncident(start.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, event.ID, finished.ID)
+	assert.Equal(t, Resolved, finished.Status)
+	assert.Equal(t, start.Add(time.Hour), finished.EndTime)
+	names, events, err := r.GetFinishedEvents()
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, event.ID, events[0].ID)
+	assert.Equal(t, Resolved, events[0].Status)
+
+	require.NoError(t, r.DeleteEventFile(names[0]))
+	assert.Empty(t, dirs.files(t))
+}
+
+func TestRecorderStartIncidentTorn(t *testing.T) {
+	for _, step := range steps {
+		t.Run(step, func(t *testing.T) {
+			dirs := newRecorderDirs(t)
+			_, err := dirs.open(t, &crashingFS{step: step}).StartIncident(time.Now())
+			require.Error(t, err)
+
+			r := dirs.open(t, osFileSystem{})
+			event, err := r.GetMostRecentEvent()
+			if committed(step) {
+				require.NoError(t, err)
+				assert.Equal(t, Ongoing, event.Status)
+			} else {
+				assert.EqualError(t, err, "no outage events recorded")
+			}
+			for _, file := range dirs.files(t) {
+				assert.False(t, isTempFile(file), "leftover %v", file)
+			}
+		})
+	}
+}
+
+func TestRecorderUpdateIncidentTorn(t *testing.T) {
+	for _, step := range steps {
+		t.Run(step, func(t *testing.T) {
+			dirs := newRecorderDirs(t)
+			event, err := dirs.open(t, osFileSystem{}).StartIncident(time.Now())
+			require.NoError(t, err)
+
+			emptyAt := event.StartTime.Add(time.Hour)
+			event.EstimatedEmptyAt = &emptyAt
+			require.Error(t, dirs.open(t, &crashingFS{step: step}).UpdateIncident(*event))
+
+			// The outage survives, with the old or the new estimate.
+			ongoing, err := dirs.open(t, osFileSystem{}).GetMostRecentEvent()
+			require.NoError(t, err)
+			assert.Equal(t, event.ID, ongoing.ID)
+			assert.Equal(t, Ongoing, ongoing.Status)
+			if committed(step) {
+				require.NotNil(t, ongoing.EstimatedEmptyAt)
+				assert.True(t, emptyAt.Equal(*ongoing.EstimatedEmptyAt))
+			} else {
+				assert.Nil(t, ongoing.EstimatedEmptyAt)
+			}
+			assert.Len(t, dirs.files(t), 1)
+		})
+	}
+}
+
+func TestRecorderFinishIncidentTorn(t *testing.T) {
+	for _, step := range steps {
+		t.Run(step, func(t *testing.T) {
+			dirs := newRecorderDirs(t)
+			event, err := dirs.open(t, osFileSystem{}).StartIncident(time.Now())
+			require.NoError(t, err)
+			_, err = dirs.open(t, &crashingFS{step: step}).FinishIncident(time.Now())
+			require.Error(t, err)
+
+			r := dirs.open(t, osFileSystem{})
+			_, finished, err := r.GetFinishedEvents()
+			require.NoError(t, err)
+			if committed(step) {
+				// The resolved event is moved on recovery.
+				require.Len(t, finished, 1)
+				assert.Equal(t, event.ID, finished[0].ID)
+				assert.Equal(t, Resolved, finished[0].Status)
+				_, err := r.GetMostRecentEvent()
+				assert.EqualError(t, err, "no outage events recorded")
+			} else {
+				assert.Empty(t, finished)
+				ongoing, err := r.GetMostRecentEvent()
+				require.NoError(t, err)
+				assert.Equal(t, event.ID, ongoing.ID)
+				assert.Equal(t, Ongoing, ongoing.Status)
+			}
+			assert.Len(t, dirs.files(t), 1)
+		})
+	}
+}
+
+func TestRecorderKeepsCorruptEvent(t *testing.T) {
+	dirs := newRecorderDirs(t)
+	r := dirs.open(t, osFileSystem{})
+	require.NoError(t, os.WriteFile(filepath.Join(dirs.events, "test_1.json"), []byte(`{"status":"ongo`), 0644))
+
+	_, err := r.GetMostRecentEvent()
+	require.Error(t, err)
+	assert.Equal(t, []string{filepath.Join("events", "test_1.json.corrupt")}, dirs.files(t))
+
+	// New outages can still be recorded.
+	_, err = r.StartIncident(time.Now())
+	require.NoError(t, err)
+	_, err = r.GetMostRecentEvent()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,196 @@
+package store
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of the webhook requests. The signature is
+// sha256=<hex HMAC-SHA256 of "<timestamp>.<body>" keyed by the secret of the
+// endpoint>, and the timestamp is in Unix seconds.
+const (
+	WebhookSignatureHeader = "X-Poweroutage-Signature"
+	WebhookTimestampHeader = "X-Poweroutage-Timestamp"
+	// WebhookDeliveryHeader identifies the message, so receivers can discard
+	// it when it is delivered again.
+	WebhookDeliveryHeader = "X-Poweroutage-Delivery"
+)
+
+// ErrInvalidSignature is returned by the verification helpers when the
+// signature is missing or doesn't match.
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// ErrStaleTimestamp is returned by the verification helpers when the request
+// is older, or newer, than the tolerance, e.g. because it was replayed.
+var ErrStaleTimestamp = errors.New("webhook timestamp out of tolerance")
+
+// WebhookConfig holds the settings of the webhook publisher.
+type WebhookConfig struct {
+	// URL is where the messages are posted, signed with Secret.
+	URL    string
+	Secret string
+	// Timeout bounds each request, 10 seconds when zero.
+	Timeout time.Duration
+}
+
+// WebhookPublisher posts every message, in an Envelope, to a URL. Failed
+// requests aren't retried here: give each webhook its own outbox, which
+// retries them.
+type WebhookPublisher struct {
+	url    string
+	secret string
+	// name identifies the webhook in errors without the query, which may
+	// hold credentials.
+	name   string
+	client *http.Client
+}
+
+// NewWebhookPublisher returns a publisher to config.URL.
+func NewWebhookPublisher(config WebhookConfig) (*WebhookPublisher, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL %q", config.URL)
+	}
+	if config.Secret == "" {
+		return nil, fmt.Errorf("webhook %v has no secret", u.Host)
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	return &WebhookPublisher{
+		url:    config.URL,
+		secret: config.Secret,
+		name:   u.Host + u.Path,
+		client: newHTTPClient(config.Timeout),
+	}, nil
+}
+
+// Name names the webhook after its host and path, e.g. to name its sink and
+// outbox.
+func (p *WebhookPublisher) Name() string {
+	return "webhook-" + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, strings.TrimSuffix(p.name, "/"))
+}
+
+// SignWebhook returns the signature of body sent at timestamp.
+func SignWebhook(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature checks the signature and timestamp headers of a
+// request with body, which must have been sent within tolerance of now.
+func VerifyWebhookSignature(secret string, header http.Header, body []byte, tolerance time.Duration, now time.Time) error {
+	seconds, err := strconv.ParseInt(header.Get(WebhookTimestampHeader), 10, 64)
+	if err != nil {
+		return ErrStaleTimestamp
+	}
+	timestamp := time.Unix(seconds, 0)
+	if timestamp.Before(now.Add(-tolerance)) || timestamp.After(now.Add(tolerance)) {
+		return ErrStaleTimestamp
+	}
+	expected := SignWebhook(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(header.Get(WebhookSignatureHeader))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// maxWebhookBody is the largest body VerifyWebhookRequest reads.
+const maxWebhookBody = 1 << 20
+
+// VerifyWebhookRequest reads the body of a webhook request, checks that it
+// was signed with secret within tolerance of now, and returns its envelope.
+func VerifyWebhookRequest(r *http.Request, secret string, tolerance time.Duration) (Envelope, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody+1))
+	if err != nil {
+		return Envelope{}, fmt.Errorf("error reading webhook body: %v", err)
+	}
+	if len(body) > maxWebhookBody {
+		return Envelope{}, errors.New("webhook body too large")
+	}
+	if err := VerifyWebhookSignature(secret, r.Header, body, tolerance, time.Now()); err != nil {
+		return Envelope{}, err
+	}
+	var envelope Envelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return Envelope{}, fmt.Errorf("invalid webhook envelope: %v", err)
+	}
+	return envelope, nil
+}
+
+// Publish posts the payload in an Envelope to the webhook. The error is
+// permanent when the webhook rejected the message.
+func (p *WebhookPublisher) Publish(eventType string, payload []byte) error {
+	body, err := json.Marshal(Envelope{Type: eventType, Version: EnvelopeVersion, Payload: payload})
+	if err != nil {
+		return Permanent(fmt.Errorf("failed to serialize envelope: %v", err))
+	}
+	sum := sha256.Sum256(body)
+	delivery := hex.EncodeToString(sum[:])
+
+	req, err := http.NewRequest(http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(fmt.Errorf("error creating webhook request: %v", err))
+	}
+	// Signed on every attempt, so retries don't look like replays.
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(p.secret, now, body))
+	req.Header.Set(WebhookDeliveryHeader, delivery)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		// The error of the client repeats the URL.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("error posting webhook %v: %v", p.name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrorBody))
+		return nil
+	}
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	httpErr := &HTTPError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(snippet))}
+	if retryableStatus(resp.StatusCode) {
+		return fmt.Errorf("error posting webhook %v: %w", p.name, httpErr)
+	}
+	return Permanent(fmt.Errorf("webhook %v rejected the message: %w", p.name, httpErr))
+}
+
+func (p *WebhookPublisher) PublishOutageEvent(event OutageEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return p.Publish(OutageEventType, payload)
+}
+
+func (p *WebhookPublisher) Close() error {
+	p.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,152 @@
+package store
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// webhookServer verifies the requests with secret and answers them with the
+// next of statuses, 200 when they run out.
+type webhookServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	statuses  []int
+	envelopes []Envelope
+	headers   []http.Header
+}
+
+func newWebhookServer(t *testing.T, secret string, statuses ...int) *webhookServer {
+	s := &webhookServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Clone()
+		envelope, err := VerifyWebhookRequest(r, secret, time.Minute)
+		assert.NoError(t, err)
+		s.mu.Lock()
+		s.envelopes = append(s.envelopes, envelope)
+		s.headers = append(s.headers, header)
+		status := http.StatusOK
+		if len(s.statuses) > 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+		}
+		s.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *webhookServer) requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.envelopes)
+}
+
+func newTestWebhookPublisher(t *testing.T, url, secret string) *WebhookPublisher {
+	publisher, err := NewWebhookPublisher(WebhookConfig{URL: url, Secret: secret})
+	require.NoError(t, err)
+	t.Cleanup(func() { publisher.Close() })
+	return publisher
+}
+
+func TestWebhookSignsRequests(t *testing.T) {
+	first := newWebhookServer(t, "first-secret")
+	second := newWebhookServer(t, "second-secret")
+
+	for _, webhook := range []struct {
+		server *webhookServer
+		secret string
+	}{{first, "first-secret"}, {second, "second-secret"}} {
+		publisher := newTestWebhookPublisher(t, webhook.server.URL+"/hook", webhook.secret)
+		require.NoError(t, publisher.Publish("probe", []byte(`{"status":"healthy"}`)))
+		require.NoError(t, publisher.PublishOutageEvent(OutageEvent{ID: "outage-1", Status: Ongoing}))
+	}
+
+	for _, server := range []*webhookServer{first, second} {
+		require.Equal(t, 2, server.requests())
+		assert.Equal(t, Envelope{Type: "probe", Version: "1", Payload: []byte(`{"status":"healthy"}`)}, server.envelopes[0])
+		assert.Equal(t, "power_outage_incident", server.envelopes[1].Type)
+		assert.Equal(t, "application/json", server.headers[0].Get("Content-Type"))
+		assert.NotEmpty(t, server.headers[0].Get(WebhookDeliveryHeader))
+	}
+	assert.Equal(t, first.headers[0].Get(WebhookDeliveryHeader), second.headers[0].Get(WebhookDeliveryHeader))
+	assert.NotEqual(t, first.headers[0].Get(WebhookSignatureHeader), second.headers[0].Get(WebhookSignatureHeader))
+}
+
+func TestWebhookErrors(t *testing.T) {
+	t.Run("unavailable", func(t *testing.T) {
+		server := newWebhookServer(t, "secret", http.StatusServiceUnavailable)
+		publisher := newTestWebhookPublisher(t, server.URL, "secret")
+
+		// Left to the outbox, which sends the same delivery again.
+		err := publisher.Publish("probe", []byte("{}"))
+		require.Error(t, err)
+		assert.False(t, IsPermanent(err))
+		require.NoError(t, publisher.Publish("probe", []byte("{}")))
+		assert.Equal(t, 2, server.requests())
+		assert.Equal(t, server.headers[0].Get(WebhookDeliveryHeader), server.headers[1].Get(WebhookDeliveryHeader))
+	})
+	t.Run("rejected", func(t *testing.T) {
+		server := newWebhookServer(t, "secret", http.StatusBadRequest)
+		publisher := newTestWebhookPublisher(t, server.URL, "secret")
+
+		err := publisher.Publish("probe", []byte("{}"))
+		require.Error(t, err)
+		assert.True(t, IsPermanent(err))
+		var httpErr *HTTPError
+		require.True(t, errors.As(err, &httpErr))
+		assert.Equal(t, http.StatusBadRequest, httpErr.StatusCode)
+		assert.Equal(t, 1, server.requests())
+	})
+}
+
+func TestWebhookName(t *testing.T) {
+	for url, name := range map[string]string{
+		"https://example.com":                   "webhook-example.com",
+		"https://example.com/hooks/outage/":     "webhook-example.com_hooks_outage",
+		"http://127.0.0.1:8080/hook?token=1234": "webhook-127.0.0.1_8080_hook",
+	} {
+		assert.Equal(t, name, newTestWebhookPublisher(t, url, "secret").Name(), url)
+	}
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"type":"probe"}`)
+	header := func(timestamp time.Time, signature string) http.Header {
+		h := http.Header{}
+		h.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp.Unix(), 10))
+		h.Set(WebhookSignatureHeader, signature)
+		return h
+	}
+
+	valid := header(now, SignWebhook("secret", now, body))
+	assert.NoError(t, VerifyWebhookSignature("secret", valid, body, time.Minute, now.Add(30*time.Second)))
+	assert.ErrorIs(t, VerifyWebhookSignature("other", valid, body, time.Minute, now), ErrInvalidSignature)
+	assert.ErrorIs(t, VerifyWebhookSignature("secret", valid, []byte(`{"type":"outage"}`), time.Minute, now), ErrInvalidSignature)
+	assert.ErrorIs(t, VerifyWebhookSignature("secret", valid, body, time.Minute, now.Add(2*time.Minute)), ErrStaleTimestamp)
+	assert.ErrorIs(t, VerifyWebhookSignature("secret", http.Header{}, body, time.Minute, now), ErrStaleTimestamp)
+
+	// The timestamp is part of the signature.
+	moved := header(now.Add(time.Second), SignWebhook("secret", now, body))
+	assert.ErrorIs(t, VerifyWebhookSignature("secret", moved, body, time.Minute, now), ErrInvalidSignature)
+}
+
+func TestNewWebhookPublisherValidatesConfig(t *testing.T) {
+	for _, config := range []WebhookConfig{
+		{},
+		{URL: "ftp://example.com", Secret: "secret"},
+		{URL: "https://example.com/hook"},
+	} {
+		_, err := NewWebhookPublisher(config)
+		assert.Error(t, err, "%v", config.URL)
+	}
+}
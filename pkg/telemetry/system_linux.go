@@ -0,0 +1,77 @@
+package telemetry
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// timeError is the state adjtimex returns while the clock is unsynchronized.
+const timeError = 5
+
+// wirelessPath lists the signal level of the wireless interfaces.
+var wirelessPath = "/proc/net/wireless"
+
+func freeDisk(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
+
+func wifi() (string, int, error) {
+	rssi, err := readRSSI(wirelessPath)
+	if err != nil {
+		return "", 0, err
+	}
+	// iwgetid comes with wireless-tools, installed for WiFi Connect.
+	out, err := exec.Command("iwgetid", "-r").Output()
+	if err != nil {
+		return "", 0, fmt.Errorf("error running iwgetid: %v", err)
+	}
+	return strings.TrimSpace(string(out)), rssi, nil
+}
+
+// readRSSI returns the signal level of the first wireless interface, from a
+// file such as:
+//
+//	Inter-| sta-|   Quality        |   Discarded packets
+//	 face | tus | link level noise |  nwid  crypt   frag
+//	wlan0: 0000   56.  -54.  -256        0      0      0
+func readRSSI(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 0; scanner.Scan(); line++ {
+		if line < 2 {
+			continue
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		level, err := strconv.ParseFloat(strings.TrimSuffix(fields[3], "."), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid signal level %q: %v", fields[3], err)
+		}
+		return int(level), nil
+	}
+	return 0, fmt.Errorf("no wireless interface")
+}
+
+func clockSynced() (bool, error) {
+	var timex syscall.Timex
+	state, err := syscall.Adjtimex(&timex)
+	if err != nil {
+		return false, err
+	}
+	return state != timeError, nil
+}
@@ -0,0 +1,33 @@
+package telemetry
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadRSSI(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wireless")
+	require.NoError(t, os.WriteFile(path, []byte(
+		"Inter-| sta-|   Quality        |   Discarded packets               | Missed | WE\n"+
+			" face | tus | link level noise |  nwid  crypt   frag  retry   misc | beacon | 22\n"+
+			"wlan0: 0000   56.  -54.  -256        0      0      0      0      0        0\n"), 0644))
+	rssi, err := readRSSI(path)
+	require.NoError(t, err)
+	assert.Equal(t, -54, rssi)
+
+	require.NoError(t, os.WriteFile(path, []byte(
+		"Inter-| sta-|   Quality        |   Discarded packets               | Missed | WE\n"+
+			" face | tus | link level noise |  nwid  crypt   frag  retry   misc | beacon | 22\n"), 0644))
+	_, err = readRSSI(path)
+	assert.EqualError(t, err, "no wireless interface")
+}
+
+func TestFreeDisk(t *testing.T) {
+	free, err := freeDisk(t.TempDir())
+	require.NoError(t, err)
+	assert.Greater(t, free, uint64(0))
+}
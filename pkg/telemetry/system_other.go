@@ -0,0 +1,19 @@
+//go:build !linux
+
+package telemetry
+
+import "errors"
+
+var errUnsupported = errors.New("not supported on this platform")
+
+func freeDisk(path string) (uint64, error) {
+	return 0, errUnsupported
+}
+
+func wifi() (string, int, error) {
+	return "", 0, errUnsupported
+}
+
+func clockSynced() (bool, error) {
+	return false, errUnsupported
+}
@@ -0,0 +1,90 @@
+package telemetry
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Snapshot is the state of the device sent with every probe. The fields that
+// couldn't be read are left out.
+type Snapshot struct {
+	BatteryVoltage_V *float32 `json:"battery_voltage_v,omitempty"`
+	// StateOfCharge is the estimated battery charge, in percent.
+	StateOfCharge *float32 `json:"state_of_charge,omitempty"`
+	Current_mA    *float32 `json:"current_ma,omitempty"`
+	Power_W       *float32 `json:"power_w,omitempty"`
+
+	// Uptime_s is how long the monitor has been running.
+	Uptime_s       int64   `json:"uptime_s"`
+	Version        string  `json:"version,omitempty"`
+	FreeDisk_bytes *uint64 `json:"free_disk_bytes,omitempty"`
+	// PendingSync is how many finished outages wait to be published.
+	PendingSync  *int   `json:"pending_sync,omitempty"`
+	WiFiSSID     string `json:"wifi_ssid,omitempty"`
+	WiFiRSSI_dBm *int   `json:"wifi_rssi_dbm,omitempty"`
+	// ClockSynced tells whether the kernel clock is synchronized, e.g. by
+	// NTP. Times sent by a device that isn't can't be trusted.
+	ClockSynced *bool `json:"clock_synced,omitempty"`
+}
+
+// Collector gathers the parts of the Snapshot that come from the system.
+// The monitor fills in the battery readings.
+type Collector struct {
+	version string
+	dataDir string
+	started time.Time
+	backlog func() (int, error)
+
+	// The system sources, tests replace them.
+	freeDisk    func(path string) (uint64, error)
+	wifi        func() (ssid string, rssi int, err error)
+	clockSynced func() (bool, error)
+}
+
+// NewCollector returns a collector for the monitor started at started.
+// Free disk space is measured on the file system of dataDir, and backlog
+// returns how many events wait to be published.
+func NewCollector(version, dataDir string, started time.Time, backlog func() (int, error)) *Collector {
+	return &Collector{
+		version:     version,
+		dataDir:     dataDir,
+		started:     started,
+		backlog:     backlog,
+		freeDisk:    freeDisk,
+		wifi:        wifi,
+		clockSynced: clockSynced,
+	}
+}
+
+// Collect returns the state of the system at now.
+func (c *Collector) Collect(now time.Time) Snapshot {
+	snapshot := Snapshot{
+		Uptime_s: int64(now.Sub(c.started).Seconds()),
+		Version:  c.version,
+	}
+	if free, err := c.freeDisk(c.dataDir); err == nil {
+		snapshot.FreeDisk_bytes = &free
+	} else {
+		log.Debugf("could not read free disk space: %v", err)
+	}
+	if c.backlog != nil {
+		if pending, err := c.backlog(); err == nil {
+			snapshot.PendingSync = &pending
+		} else {
+			log.Debugf("could not count the events pending sync: %v", err)
+		}
+	}
+	if ssid, rssi, err := c.wifi(); err == nil {
+		snapshot.WiFiSSID = ssid
+		snapshot.WiFiRSSI_dBm = &rssi
+	} else {
+		log.Debugf("could not read the Wi-Fi status: %v", err)
+	}
+	if synced, err := c.clockSynced(); err == nil {
+		snapshot.ClockSynced = &synced
+	} else {
+		log.Debugf("could not read the clock status: %v", err)
+	}
+	return snapshot
+}
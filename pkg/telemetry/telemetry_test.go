@@ -0,0 +1,48 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollect(t *testing.T) {
+	started := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewCollector("0123456789ab", "/data", started, func() (int, error) { return 2, nil })
+	c.freeDisk = func(path string) (uint64, error) {
+		assert.Equal(t, "/data", path)
+		return 1 << 30, nil
+	}
+	c.wifi = func() (string, int, error) { return "casa", -54, nil }
+	c.clockSynced = func() (bool, error) { return true, nil }
+
+	snapshot := c.Collect(started.Add(90 * time.Minute))
+	assert.Equal(t, int64(5400), snapshot.Uptime_s)
+	assert.Equal(t, "0123456789ab", snapshot.Version)
+	require.NotNil(t, snapshot.FreeDisk_bytes)
+	assert.Equal(t, uint64(1<<30), *snapshot.FreeDisk_bytes)
+	require.NotNil(t, snapshot.PendingSync)
+	assert.Equal(t, 2, *snapshot.PendingSync)
+	assert.Equal(t, "casa", snapshot.WiFiSSID)
+	require.NotNil(t, snapshot.WiFiRSSI_dBm)
+	assert.Equal(t, -54, *snapshot.WiFiRSSI_dBm)
+	require.NotNil(t, snapshot.ClockSynced)
+	assert.True(t, *snapshot.ClockSynced)
+}
+
+func TestCollectLeavesOutWhatFails(t *testing.T) {
+	started := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	failed := errors.New("failed")
+	c := NewCollector("", "/data", started, func() (int, error) { return 0, failed })
+	c.freeDisk = func(path string) (uint64, error) { return 0, failed }
+	c.wifi = func() (string, int, error) { return "", 0, failed }
+	c.clockSynced = func() (bool, error) { return false, failed }
+
+	data, err := json.Marshal(c.Collect(started))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"uptime_s": 0}`, string(data))
+}
@@ -0,0 +1,267 @@
+package ups
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// calibrationScale is the fixed 0.04096 term of the INA219 calibration
+// equation (datasheet section 8.5.1).
+const calibrationScale = 0.04096
+
+// Calibration describes how the INA219 is wired and configured. Compute
+// derives the register values from it following the datasheet procedure.
+type Calibration struct {
+	// ShuntOhms is the value of the shunt resistor.
+	ShuntOhms float64
+	// MaxExpectedCurrent_A is the largest current we expect to measure.
+	MaxExpectedCurrent_A float64
+	BusVoltageRange      BusVoltageRange
+	Gain                 Gain
+	BusADCResolution     ADCResolution
+	ShuntADCResolution   ADCResolution
+	Mode                 Mode
+	// CurrentLSB_A overrides the current LSB. When zero, the smallest round
+	// number (1, 2 or 5 times a power of ten) above the minimum LSB is used.
+	CurrentLSB_A float64
+}
+
+// CalibrationValues are the values derived from a Calibration.
+type CalibrationValues struct {
+	// CalValue is written to the calibration register.
+	CalValue uint16
+	// Config is written to the config register.
+	Config uint16
+	// CurrentLSB_mA is the value of one bit of the current register.
+	CurrentLSB_mA float64
+	// PowerLSB_W is the value of one bit of the power register.
+	PowerLSB_W float64
+	// MaxCurrent_A is the largest current measurable before overflow.
+	MaxCurrent_A float64
+	// MaxShuntVoltage_V is the largest shunt voltage before overflow.
+	MaxShuntVoltage_V float64
+	// MaxPower_W is the largest power measurable before overflow.
+	MaxPower_W float64
+}
+
+// Calibration16V5A is the setup of the Waveshare UPS HAT: a 10mΩ shunt and
+// up to 5A. The current LSB is the one used by the vendor's sample code.
+var Calibration16V5A = Calibration{
+	ShuntOhms:            0.01,
+	MaxExpectedCurrent_A: 5,
+	BusVoltageRange:      RANGE_16V,
+	Gain:                 DIV_2_80MV,
+	BusADCResolution:     ADCRES_12BIT_32S,
+	ShuntADCResolution:   ADCRES_12BIT_32S,
+	Mode:                 SANDBVOLT_CONTINUOUS,
+	CurrentLSB_A:         0.0001524,
+}
+
+// Calibration32V2A is the calibration example of the datasheet: a 100mΩ
+// shunt and up to 2A.
+var Calibration32V2A = Calibration{
+	ShuntOhms:            0.1,
+	MaxExpectedCurrent_A: 2,
+	BusVoltageRange:      RANGE_32V,
+	Gain:                 DIV_8_320MV,
+	BusADCResolution:     ADCRES_12BIT_1S,
+	ShuntADCResolution:   ADCRES_12BIT_1S,
+	Mode:                 SANDBVOLT_CONTINUOUS,
+}
+
+// Calibration32V1A trades range for resolution on a 100mΩ shunt.
+var Calibration32V1A = Calibration{
+	ShuntOhms:            0.1,
+	MaxExpectedCurrent_A: 1,
+	BusVoltageRange:      RANGE_32V,
+	Gain:                 DIV_8_320MV,
+	BusADCResolution:     ADCRES_12BIT_1S,
+	ShuntADCResolution:   ADCRES_12BIT_1S,
+	Mode:                 SANDBVOLT_CONTINUOUS,
+	CurrentLSB_A:         0.00004,
+}
+
+// Calibration16V400mA gives the best resolution on a 100mΩ shunt.
+var Calibration16V400mA = Calibration{
+	ShuntOhms:            0.1,
+	MaxExpectedCurrent_A: 0.4,
+	BusVoltageRange:      RANGE_16V,
+	Gain:                 DIV_1_40MV,
+	BusADCResolution:     ADCRES_12BIT_1S,
+	ShuntADCResolution:   ADCRES_12BIT_1S,
+	Mode:                 SANDBVOLT_CONTINUOUS,
+	CurrentLSB_A:         0.00005,
+}
+
+var calibrationPresets = map[string]Calibration{
+	"16v5a":    Calibration16V5A,
+	"32v2a":    Calibration32V2A,
+	"32v1a":    Calibration32V1A,
+	"16v400ma": Calibration16V400mA,
+}
+
+// CalibrationPreset returns one of the predefined calibrations by name,
+// e.g. "16V5A". An empty name returns the UPS HAT calibration.
+func CalibrationPreset(name string) (Calibration, error) {
+	if name == "" {
+		return Calibration16V5A, nil
+	}
+	cal, ok := calibrationPresets[strings.ToLower(name)]
+	if !ok {
+		return Calibration{}, fmt.Errorf("unknown calibration preset: %v", name)
+	}
+	return cal, nil
+}
+
+// BusVoltageRangeFromVolts maps 16 or 32 to the matching bus voltage range.
+func BusVoltageRangeFromVolts(volts int) (BusVoltageRange, error) {
+	switch volts {
+	case 16:
+		return RANGE_16V, nil
+	case 32:
+		return RANGE_32V, nil
+	}
+	return 0, fmt.Errorf("invalid bus voltage range: %vV, must be 16 or 32", volts)
+}
+
+// GainFromMillivolts maps a shunt voltage range in mV to the matching gain.
+func GainFromMillivolts(mv int) (Gain, error) {
+	switch mv {
+	case 40:
+		return DIV_1_40MV, nil
+	case 80:
+		return DIV_2_80MV, nil
+	case 160:
+		return DIV_4_160MV, nil
+	case 320:
+		return DIV_8_320MV, nil
+	}
+	return 0, fmt.Errorf("invalid shunt voltage range: %vmV, must be 40, 80, 160 or 320", mv)
+}
+
+// ADCResolutionFromSamples returns the 12 bit resolution that averages the
+// given number of samples.
+func ADCResolutionFromSamples(samples int) (ADCResolution, error) {
+	switch samples {
+	case 1:
+		return ADCRES_12BIT_1S, nil
+	case 2:
+		return ADCRES_12BIT_2S, nil
+	case 4:
+		return ADCRES_12BIT_4S, nil
+	case 8:
+		return ADCRES_12BIT_8S, nil
+	case 16:
+		return ADCRES_12BIT_16S, nil
+	case 32:
+		return ADCRES_12BIT_32S, nil
+	case 64:
+		return ADCRES_12BIT_64S, nil
+	case 128:
+		return ADCRES_12BIT_128S, nil
+	}
+	return 0, fmt.Errorf("invalid ADC sample count: %v, must be a power of two up to 128", samples)
+}
+
+// maxBusVoltage returns the full scale of the bus voltage range.
+func (r BusVoltageRange) maxBusVoltage() float64 {
+	if r == RANGE_16V {
+		return 16
+	}
+	return 32
+}
+
+// maxShuntVoltage returns the full scale shunt voltage of the gain.
+func (g Gain) maxShuntVoltage() (float64, error) {
+	switch g {
+	case DIV_1_40MV:
+		return 0.04, nil
+	case DIV_2_80MV:
+		return 0.08, nil
+	case DIV_4_160MV:
+		return 0.16, nil
+	case DIV_8_320MV:
+		return 0.32, nil
+	}
+	return 0, fmt.Errorf("invalid gain: %v", uint16(g))
+}
+
+// Compute follows the calibration procedure of the datasheet:
+//
+//  1. MaxPossible_I = VSHUNT_MAX / RSHUNT
+//  2. MinimumLSB = MaxExpected_I / 32767, MaximumLSB = MaxExpected_I / 4096
+//  3. Pick Current_LSB between both, preferably a round number close to the
+//     minimum
+//  4. Cal = trunc(0.04096 / (Current_LSB * RSHUNT))
+//  5. Power_LSB = 20 * Current_LSB
+//  6. Max_Current = min(Current_LSB * 32767, MaxPossible_I) and the matching
+//     shunt voltage and power before overflow.
+func (c Calibration) Compute() (CalibrationValues, error) {
+	if c.ShuntOhms <= 0 {
+		return CalibrationValues{}, fmt.Errorf("shunt resistance must be positive, got %v", c.ShuntOhms)
+	}
+	if c.MaxExpectedCurrent_A <= 0 {
+		return CalibrationValues{}, fmt.Errorf("max expected current must be positive, got %v", c.MaxExpectedCurrent_A)
+	}
+	maxShuntVoltage, err := c.Gain.maxShuntVoltage()
+	if err != nil {
+		return CalibrationValues{}, err
+	}
+
+	maxPossibleCurrent := maxShuntVoltage / c.ShuntOhms
+	if c.MaxExpectedCurrent_A > maxPossibleCurrent*(1+1e-9) {
+		return CalibrationValues{}, fmt.Errorf(
+			"max expected current %vA is above the %vA the shunt can measure with a %vV range",
+			c.MaxExpectedCurrent_A, maxPossibleCurrent, maxShuntVoltage)
+	}
+
+	minimumLSB := c.MaxExpectedCurrent_A / 32767
+	maximumLSB := c.MaxExpectedCurrent_A / 4096
+	currentLSB := c.CurrentLSB_A
+	if currentLSB == 0 {
+		currentLSB = roundLSB(minimumLSB)
+	}
+	if currentLSB < 0 || currentLSB > maximumLSB {
+		return CalibrationValues{}, fmt.Errorf("current LSB %vA is outside of (0, %vA]", currentLSB, maximumLSB)
+	}
+
+	cal := math.Trunc(calibrationScale/(currentLSB*c.ShuntOhms) + 1e-9)
+	if cal < 1 || cal > math.MaxUint16 {
+		return CalibrationValues{}, fmt.Errorf("calibration value %v doesn't fit the calibration register", cal)
+	}
+
+	maxCurrent := math.Min(currentLSB*32767, maxPossibleCurrent)
+	maxShunt := math.Min(maxCurrent*c.ShuntOhms, maxShuntVoltage)
+
+	return CalibrationValues{
+		// Bit 0 of the calibration register is void and always reads 0.
+		CalValue:          uint16(cal) &^ 1,
+		Config:            c.config(),
+		CurrentLSB_mA:     currentLSB * 1000,
+		PowerLSB_W:        currentLSB * 20,
+		MaxCurrent_A:      maxCurrent,
+		MaxShuntVoltage_V: maxShunt,
+		MaxPower_W:        maxCurrent * c.BusVoltageRange.maxBusVoltage(),
+	}, nil
+}
+
+func (c Calibration) config() uint16 {
+	return uint16(c.BusVoltageRange)<<13 |
+		uint16(c.Gain)<<11 |
+		uint16(c.BusADCResolution)<<7 |
+		uint16(c.ShuntADCResolution)<<3 |
+		uint16(c.Mode)
+}
+
+// roundLSB returns the smallest value of the 1-2-5 series that is not below
+// lsb.
+func roundLSB(lsb float64) float64 {
+	decade := math.Pow(10, math.Floor(math.Log10(lsb)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if candidate := m * decade; candidate >= lsb*(1-1e-9) {
+			return candidate
+		}
+	}
+	return 10 * decade
+}
@@ -0,0 +1,117 @@
+package ups
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalibrationDatasheetExample(t *testing.T) {
+	// The datasheet example: 32V, 320mV shunt range, 0.1Ω and 2A expected.
+	values, err := Calibration32V2A.Compute()
+	require.NoError(t, err)
+
+	assert.Equal(t, uint16(4096), values.CalValue)
+	assert.InDelta(t, 0.1, values.CurrentLSB_mA, 1e-9)
+	assert.InDelta(t, 0.002, values.PowerLSB_W, 1e-9)
+	assert.InDelta(t, 3.2, values.MaxCurrent_A, 1e-9)
+	assert.InDelta(t, 0.32, values.MaxShuntVoltage_V, 1e-9)
+	assert.InDelta(t, 102.4, values.MaxPower_W, 1e-9)
+	// Power on reset value of the config register.
+	assert.Equal(t, uint16(0x399F), values.Config)
+}
+
+func TestCalibrationPresets(t *testing.T) {
+	tests := []struct {
+		name       string
+		calValue   uint16
+		currentLSB float64
+		powerLSB   float64
+		config     uint16
+	}{
+		{"16V5A", 26876, 0.1524, 0.003048, 0x0EEF},
+		{"32V2A", 4096, 0.1, 0.002, 0x399F},
+		{"32V1A", 10240, 0.04, 0.0008, 0x399F},
+		{"16V400mA", 8192, 0.05, 0.001, 0x019F},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cal, err := CalibrationPreset(tt.name)
+			require.NoError(t, err)
+			values, err := cal.Compute()
+			require.NoError(t, err)
+			assert.Equal(t, tt.calValue, values.CalValue)
+			assert.InDelta(t, tt.currentLSB, values.CurrentLSB_mA, 1e-9)
+			assert.InDelta(t, tt.powerLSB, values.PowerLSB_W, 1e-9)
+			assert.Equal(t, tt.config, values.Config)
+		})
+	}
+
+	_, err := CalibrationPreset("48V10A")
+	assert.Error(t, err)
+}
+
+func TestCalibrationPicksRoundLSB(t *testing.T) {
+	cal := Calibration{
+		ShuntOhms:            0.01,
+		MaxExpectedCurrent_A: 5,
+		BusVoltageRange:      RANGE_16V,
+		Gain:                 DIV_2_80MV,
+		BusADCResolution:     ADCRES_12BIT_32S,
+		ShuntADCResolution:   ADCRES_12BIT_32S,
+		Mode:                 SANDBVOLT_CONTINUOUS,
+	}
+	values, err := cal.Compute()
+	require.NoError(t, err)
+
+	// 5A / 32767 = 152.6uA, rounded up to 200uA.
+	assert.InDelta(t, 0.2, values.CurrentLSB_mA, 1e-9)
+	assert.Equal(t, uint16(20480), values.CalValue)
+	assert.InDelta(t, 0.004, values.PowerLSB_W, 1e-9)
+}
+
+func TestCalibrationErrors(t *testing.T) {
+	valid := Calibration32V2A
+
+	noShunt := valid
+	noShunt.ShuntOhms = 0
+	_, err := noShunt.Compute()
+	assert.Error(t, err)
+
+	tooMuchCurrent := valid
+	tooMuchCurrent.Gain = DIV_1_40MV
+	_, err = tooMuchCurrent.Compute()
+	assert.Error(t, err, "0.04V / 0.1Ω can't measure 2A")
+
+	coarseLSB := valid
+	coarseLSB.CurrentLSB_A = 0.001
+	_, err = coarseLSB.Compute()
+	assert.Error(t, err)
+
+	overflow := valid
+	overflow.ShuntOhms = 0.0001
+	overflow.MaxExpectedCurrent_A = 0.01
+	_, err = overflow.Compute()
+	assert.Error(t, err)
+}
+
+func TestCalibrationHelpers(t *testing.T) {
+	r, err := BusVoltageRangeFromVolts(32)
+	require.NoError(t, err)
+	assert.Equal(t, RANGE_32V, r)
+	_, err = BusVoltageRangeFromVolts(24)
+	assert.Error(t, err)
+
+	g, err := GainFromMillivolts(160)
+	require.NoError(t, err)
+	assert.Equal(t, DIV_4_160MV, g)
+	_, err = GainFromMillivolts(100)
+	assert.Error(t, err)
+
+	res, err := ADCResolutionFromSamples(32)
+	require.NoError(t, err)
+	assert.Equal(t, ADCRES_12BIT_32S, res)
+	_, err = ADCResolutionFromSamples(3)
+	assert.Error(t, err)
+}
@@ -0,0 +1,37 @@
+package ups
+
+import "fmt"
+
+// DisabledSensor is used in place of the hardware when it is turned off,
+// e.g. because it keeps crashing the monitor. Every read fails.
+type DisabledSensor struct {
+	Reason string
+}
+
+func (s DisabledSensor) err() error {
+	return fmt.Errorf("power sensor is disabled: %v", s.Reason)
+}
+
+func (s DisabledSensor) GetBusVoltage_V() (float32, error) {
+	return 0, s.err()
+}
+
+func (s DisabledSensor) GetShuntVoltage_mV() (float32, error) {
+	return 0, s.err()
+}
+
+func (s DisabledSensor) GetCurrent_mA() (float32, error) {
+	return 0, s.err()
+}
+
+func (s DisabledSensor) GetPower_W() (float32, error) {
+	return 0, s.err()
+}
+
+func (s DisabledSensor) Health() error {
+	return s.err()
+}
+
+func (s DisabledSensor) Close() error {
+	return nil
+}
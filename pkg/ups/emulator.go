@@ -0,0 +1,193 @@
+package ups
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+const (
+	// busVoltageCNVR is the conversion ready bit of the bus voltage register.
+	busVoltageCNVR uint16 = 0x02
+	// busVoltageOVF is the math overflow bit of the bus voltage register.
+	busVoltageOVF uint16 = 0x01
+)
+
+// INA219Emulator is an in-memory INA219 that implements Registers. It models
+// the register arithmetic of the datasheet so UPSManager can be tested
+// without hardware:
+//
+//   - the shunt register is the shunt voltage in 10uV steps, two's complement
+//     and clipped to the range of the PGA
+//   - the bus register holds the bus voltage in 4mV steps in bits 3-15, plus
+//     the CNVR and OVF flags
+//   - current = shunt * calibration / 4096, two's complement
+//   - power = |current| * bus / 5000
+//
+// Conversions are instantaneous: they run whenever the load changes or the
+// config or calibration registers are written, unless the ADC is off.
+type INA219Emulator struct {
+	mu sync.Mutex
+
+	shuntOhms  float64
+	busVoltage float64
+	current    float64
+
+	config      uint16
+	calibration uint16
+	shuntReg    int16
+	busReg      uint16
+	currentReg  int16
+	powerReg    uint16
+	cnvr        bool
+	ovf         bool
+
+	readErr  error
+	writeErr error
+}
+
+// NewINA219Emulator returns an emulator in its power on state, measuring
+// the current through a shunt of shuntOhms.
+func NewINA219Emulator(shuntOhms float64) *INA219Emulator {
+	e := &INA219Emulator{shuntOhms: shuntOhms}
+	e.reset()
+	return e
+}
+
+func (e *INA219Emulator) reset() {
+	e.config = configResetValue
+	e.calibration = 0
+	e.shuntReg = 0
+	e.busReg = 0
+	e.currentReg = 0
+	e.powerReg = 0
+	e.cnvr = false
+	e.ovf = false
+}
+
+// SetLoad sets the voltage on the bus and the current flowing through the
+// shunt, negative when the battery is discharging, and runs a conversion.
+func (e *INA219Emulator) SetLoad(busVoltage_V, current_A float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.busVoltage = busVoltage_V
+	e.current = current_A
+	e.convert()
+}
+
+// SetReadError makes every read fail with err until it is set to nil.
+func (e *INA219Emulator) SetReadError(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.readErr = err
+}
+
+// SetWriteError makes every write fail with err until it is set to nil.
+func (e *INA219Emulator) SetWriteError(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.writeErr = err
+}
+
+func (e *INA219Emulator) convert() {
+	mode := Mode(e.config & 0x07)
+	if mode == POWERDOW || mode == ADCOFF {
+		return
+	}
+
+	maxShunt, _ := Gain((e.config >> 11) & 0x03).maxShuntVoltage()
+	shunt := clamp(e.current*e.shuntOhms, -maxShunt, maxShunt)
+	e.shuntReg = int16(math.Round(shunt / 0.00001))
+
+	maxBus := BusVoltageRange((e.config >> 13) & 0x01).maxBusVoltage()
+	bus := clamp(e.busVoltage, 0, maxBus)
+	e.busReg = uint16(math.Min(math.Round(bus/0.004), 0x1FFF))
+
+	e.ovf = false
+	current := int64(e.shuntReg) * int64(e.calibration) / 4096
+	if current > math.MaxInt16 || current < math.MinInt16 {
+		e.ovf = true
+		current = int64(clamp(float64(current), math.MinInt16, math.MaxInt16))
+	}
+	e.currentReg = int16(current)
+
+	if current < 0 {
+		current = -current
+	}
+	power := current * int64(e.busReg) / 5000
+	if power > math.MaxUint16 {
+		e.ovf = true
+		power = math.MaxUint16
+	}
+	e.powerReg = uint16(power)
+	e.cnvr = true
+}
+
+func clamp(v, low, high float64) float64 {
+	return math.Max(low, math.Min(high, v))
+}
+
+func (e *INA219Emulator) ReadRegister(reg byte) (uint16, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.readErr != nil {
+		return 0, e.readErr
+	}
+
+	switch reg {
+	case regConfig:
+		return e.config, nil
+	case regShuntVoltage:
+		return uint16(e.shuntReg), nil
+	case regBusVoltage:
+		value := e.busReg << 3
+		if e.cnvr {
+			value |= busVoltageCNVR
+		}
+		if e.ovf {
+			value |= busVoltageOVF
+		}
+		return value, nil
+	case regPower:
+		// Reading the power register clears the conversion ready flag.
+		e.cnvr = false
+		return e.powerReg, nil
+	case regCurrent:
+		return uint16(e.currentReg), nil
+	case regCalibration:
+		return e.calibration, nil
+	}
+	return 0, fmt.Errorf("invalid register 0x%02x", reg)
+}
+
+func (e *INA219Emulator) WriteRegister(reg byte, value uint16) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.writeErr != nil {
+		return e.writeErr
+	}
+
+	switch reg {
+	case regConfig:
+		if value&configReset != 0 {
+			e.reset()
+			return nil
+		}
+		e.config = value
+		e.cnvr = false
+	case regCalibration:
+		// Bit 0 of the calibration register is void.
+		e.calibration = value &^ 1
+	default:
+		return fmt.Errorf("register 0x%02x is read only", reg)
+	}
+	e.convert()
+	return nil
+}
+
+// Close does nothing, like closing a real bus the chip keeps its registers.
+func (e *INA219Emulator) Close() error {
+	return nil
+}
+
+var _ Registers = (*INA219Emulator)(nil)
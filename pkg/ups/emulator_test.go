@@ -0,0 +1,126 @@
+package ups
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmulatorPowerOnState(t *testing.T) {
+	emu := NewINA219Emulator(0.1)
+
+	config, err := emu.ReadRegister(regConfig)
+	require.NoError(t, err)
+	assert.Equal(t, configResetValue, config)
+
+	cal, err := emu.ReadRegister(regCalibration)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(0), cal)
+
+	// Without calibration there is no current or power.
+	emu.SetLoad(12, 1)
+	current, err := emu.ReadRegister(regCurrent)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(0), current)
+
+	_, err = emu.ReadRegister(0x06)
+	assert.Error(t, err)
+	assert.Error(t, emu.WriteRegister(regCurrent, 1))
+}
+
+func TestEmulatorRegisterArithmetic(t *testing.T) {
+	emu := NewINA219Emulator(0.1)
+	require.NoError(t, emu.WriteRegister(regCalibration, 4096))
+	emu.SetLoad(12, 1)
+
+	// 1A through 0.1Ω is 100mV, or 10000 steps of 10uV.
+	shunt, err := emu.ReadRegister(regShuntVoltage)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(10000), shunt)
+
+	bus, err := emu.ReadRegister(regBusVoltage)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(3000), bus>>3)
+	assert.NotZero(t, bus&busVoltageCNVR, "conversion ready")
+	assert.Zero(t, bus&busVoltageOVF)
+
+	current, err := emu.ReadRegister(regCurrent)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(10000), current)
+
+	power, err := emu.ReadRegister(regPower)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(10000*3000/5000), power)
+
+	// Reading the power register clears CNVR.
+	bus, err = emu.ReadRegister(regBusVoltage)
+	require.NoError(t, err)
+	assert.Zero(t, bus&busVoltageCNVR)
+}
+
+func TestEmulatorNegativeCurrent(t *testing.T) {
+	emu := NewINA219Emulator(0.1)
+	require.NoError(t, emu.WriteRegister(regCalibration, 4096))
+	emu.SetLoad(5, -0.5)
+
+	shunt, err := emu.ReadRegister(regShuntVoltage)
+	require.NoError(t, err)
+	assert.Equal(t, int16(-5000), int16(shunt))
+
+	current, err := emu.ReadRegister(regCurrent)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(0xFFFF-5000+1), current)
+
+	power, err := emu.ReadRegister(regPower)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(5000*1250/5000), power)
+}
+
+func TestEmulatorOverflowAndClipping(t *testing.T) {
+	emu := NewINA219Emulator(0.1)
+	require.NoError(t, emu.WriteRegister(regCalibration, 0xFFFF))
+	emu.SetLoad(40, 10)
+
+	cal, err := emu.ReadRegister(regCalibration)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(0xFFFE), cal, "bit 0 is void")
+
+	// The shunt voltage is clipped to the 320mV range of the PGA.
+	shunt, err := emu.ReadRegister(regShuntVoltage)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(32000), shunt)
+
+	bus, err := emu.ReadRegister(regBusVoltage)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(8000), bus>>3, "clipped to 32V")
+	assert.NotZero(t, bus&busVoltageOVF)
+}
+
+func TestEmulatorReset(t *testing.T) {
+	emu := NewINA219Emulator(0.1)
+	require.NoError(t, emu.WriteRegister(regCalibration, 4096))
+	require.NoError(t, emu.WriteRegister(regConfig, 0x0EEF))
+
+	require.NoError(t, emu.WriteRegister(regConfig, configReset))
+	config, err := emu.ReadRegister(regConfig)
+	require.NoError(t, err)
+	assert.Equal(t, configResetValue, config)
+	cal, err := emu.ReadRegister(regCalibration)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(0), cal)
+}
+
+func TestEmulatorADCOff(t *testing.T) {
+	emu := NewINA219Emulator(0.1)
+	require.NoError(t, emu.WriteRegister(regCalibration, 4096))
+	require.NoError(t, emu.WriteRegister(regConfig, uint16(ADCOFF)))
+	emu.SetLoad(12, 1)
+
+	shunt, err := emu.ReadRegister(regShuntVoltage)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(0), shunt)
+	bus, err := emu.ReadRegister(regBusVoltage)
+	require.NoError(t, err)
+	assert.Zero(t, bus&busVoltageCNVR)
+}
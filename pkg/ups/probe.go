@@ -0,0 +1,124 @@
+package ups
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+const (
+	// DefaultBus is the I2C bus exposed on the Raspberry Pi header.
+	DefaultBus = 1
+
+	// DefaultAddress is where the INA219 of the UPS HAT we ship answers.
+	DefaultAddress uint8 = 0x43
+
+	// configReset is the RST bit of the config register.
+	configReset uint16 = 0x8000
+
+	// configResetValue is what the config register holds after a reset.
+	configResetValue uint16 = 0x399F
+)
+
+// knownBoards names the boards usually found at each INA219 address.
+var knownBoards = map[uint8]string{
+	0x40: "INA219 breakout (default address)",
+	0x41: "INA219 breakout (A0 bridged)",
+	0x42: "Waveshare UPS HAT / UPS HAT (B)",
+	0x43: "Waveshare UPS HAT (C) / UPS HAT (D)",
+	0x44: "INA219 breakout (A1 bridged)",
+	0x45: "INA219 breakout (A0 and A1 bridged)",
+}
+
+// ProbeCandidates are the addresses scanned by Probe, in order.
+var ProbeCandidates = []uint8{0x43, 0x42, 0x41, 0x40}
+
+// ProbeResult describes an INA219 found by Probe.
+type ProbeResult struct {
+	Bus     int
+	Address uint8
+	// Board is our best guess of the board the INA219 is mounted on.
+	Board string
+}
+
+func (r ProbeResult) String() string {
+	return fmt.Sprintf("%v at 0x%02x on bus %v", r.Board, r.Address, r.Bus)
+}
+
+// ParseAddress parses an I2C address such as "0x43". "auto" returns 0, which
+// makes NewManager probe for the INA219, and the empty string returns
+// DefaultAddress.
+func ParseAddress(address string) (uint8, error) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return DefaultAddress, nil
+	}
+	if strings.EqualFold(address, "auto") {
+		return 0, nil
+	}
+	value, err := strconv.ParseUint(address, 0, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid I2C address %q: %v", address, err)
+	}
+	if value < 0x03 || value > 0x77 {
+		return 0, fmt.Errorf("I2C address %q is outside of the 0x03-0x77 range", address)
+	}
+	return uint8(value), nil
+}
+
+// Probe looks for an INA219 on bus at each of the candidate addresses. The
+// devices are only read, since other chips may share the bus: a device is
+// accepted when its config and calibration registers hold either the INA219
+// power on values or the values cal programs, e.g. after a restart of the
+// monitor.
+func Probe(bus int, candidates []uint8, cal Calibration) (ProbeResult, error) {
+	if len(candidates) == 0 {
+		candidates = ProbeCandidates
+	}
+	values, err := cal.Compute()
+	if err != nil {
+		return ProbeResult{}, errors.Wrapf(err, "invalid calibration")
+	}
+	var failures []string
+	for _, addr := range candidates {
+		err := probeAddress(bus, addr, values)
+		if err == nil {
+			board, ok := knownBoards[addr]
+			if !ok {
+				board = "INA219"
+			}
+			return ProbeResult{Bus: bus, Address: addr, Board: board}, nil
+		}
+		failures = append(failures, fmt.Sprintf("0x%02x: %v", addr, err))
+	}
+	return ProbeResult{}, fmt.Errorf(
+		"no INA219 found on I2C bus %v (%v)", bus, strings.Join(failures, "; "))
+}
+
+func probeAddress(bus int, addr uint8, values CalibrationValues) error {
+	dev, err := openDevice(bus, addr)
+	if err != nil {
+		return err
+	}
+	defer dev.Close()
+
+	config, err := dev.ReadRegister(regConfig)
+	if err != nil {
+		return errors.Wrapf(err, "no device answered")
+	}
+	calibration, err := dev.ReadRegister(regCalibration)
+	if err != nil {
+		return errors.Wrapf(err, "error reading calibration register")
+	}
+	powerOn := config == configResetValue && calibration == 0
+	// Bit 0 of the calibration register is void and reads 0.
+	calibrated := config == values.Config && calibration == values.CalValue&^1
+	if !powerOn && !calibrated {
+		return fmt.Errorf(
+			"config register reads 0x%04x and calibration register 0x%04x, expected 0x%04x and 0x0000 at power on or 0x%04x and 0x%04x once calibrated",
+			config, calibration, configResetValue, values.Config, values.CalValue&^1)
+	}
+	return nil
+}
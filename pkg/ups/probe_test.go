@@ -0,0 +1,35 @@
+package ups
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    uint8
+		wantErr bool
+	}{
+		{"", DefaultAddress, false},
+		{"auto", 0, false},
+		{"AUTO", 0, false},
+		{"0x40", 0x40, false},
+		{" 0x42 ", 0x42, false},
+		{"67", 0x43, false},
+		{"0x80", 0, true},
+		{"0x01", 0, true},
+		{"ina219", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseAddress(tt.in)
+		if tt.wantErr {
+			assert.Error(t, err, tt.in)
+			continue
+		}
+		require.NoError(t, err, tt.in)
+		assert.Equal(t, tt.want, got, tt.in)
+	}
+}
@@ -2,7 +2,6 @@ package ups
 
 import (
 	"github.com/cockroachdb/errors"
-	"github.com/d2r2/go-i2c"
 	"github.com/d2r2/go-logger"
 )
 
@@ -75,129 +74,155 @@ const (
 	SANDBVOLT_CONTINUOUS Mode = 0x07 // shunt and bus voltage continuous
 )
 
+// UPSManager is the PowerSensor implementation backed by the INA219 on the
+// Waveshare UPS HAT.
 type UPSManager struct {
-	bus        *i2c.I2C
+	bus        Registers
 	addr       uint8
+	board      string
+	cal        Calibration
 	calValue   uint16
 	currentLSB float64
 	powerLSB   float64
+	// reopen opens the bus again on Reinitialize, nil when the registers
+	// were given to NewManagerWithRegisters.
+	reopen func() (Registers, error)
 }
 
-func NewManager() *UPSManager {
+// ManagerConfig says where to find the INA219 and how to calibrate it.
+type ManagerConfig struct {
+	Bus int
+	// Address of the INA219. Zero probes ProbeCandidates.
+	Address     uint8
+	Calibration Calibration
+}
+
+// NewManager opens the INA219 described by config and programs its
+// calibration.
+func NewManager(config ManagerConfig) (*UPSManager, error) {
 	logger.ChangePackageLogLevel("i2c", logger.WarnLevel)
-	i2c, err := i2c.NewI2C(0x43, 1)
+
+	addr := config.Address
+	board, ok := knownBoards[addr]
+	if !ok {
+		board = "INA219"
+	}
+	if addr == 0 {
+		result, err := Probe(config.Bus, ProbeCandidates, config.Calibration)
+		if err != nil {
+			return nil, err
+		}
+		addr = result.Address
+		board = result.Board
+	}
+
+	regs, err := openDevice(config.Bus, addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open I2C bus %v", config.Bus)
+	}
+	ups, err := NewManagerWithRegisters(regs, config.Calibration)
 	if err != nil {
-		panic("unable to initialize I2C reader")
+		regs.Close()
+		return nil, errors.Wrapf(err,
+			"unable to configure the INA219 at 0x%02x on bus %v, check I2C_ADDRESS or set it to auto",
+			addr, config.Bus)
 	}
-	ups := &UPSManager{
-		bus: i2c,
+	ups.addr = addr
+	ups.board = board
+	ups.reopen = func() (Registers, error) {
+		return openDevice(config.Bus, addr)
 	}
 
-	ups.calValue = 0
-	ups.currentLSB = 0
-	ups.powerLSB = 0
-	ups.SetCalibration16V5A()
+	return ups, nil
+}
 
-	return ups
+// NewManagerWithRegisters programs the calibration into an already opened
+// INA219, such as an INA219Emulator.
+func NewManagerWithRegisters(regs Registers, cal Calibration) (*UPSManager, error) {
+	ups := &UPSManager{bus: regs, board: "INA219"}
+	if err := ups.SetCalibration(cal); err != nil {
+		return nil, err
+	}
+	return ups, nil
+}
+
+// Address returns the I2C address of the INA219.
+func (um *UPSManager) Address() uint8 {
+	return um.addr
+}
+
+// Board returns the name of the board the INA219 is mounted on, if known.
+func (um *UPSManager) Board() string {
+	return um.board
 }
 
 func (um *UPSManager) Close() error {
 	return um.bus.Close()
 }
 
-func (um *UPSManager) Read(address uint8) (uint16, error) {
-	data, _, err := um.bus.ReadRegBytes(address, 2)
-	if err != nil {
-		return 0, err
+// Reinitialize opens the I2C bus again, resets the INA219 and programs the
+// calibration, e.g. after the chip browned out and lost its registers. The
+// current bus is kept if it can't be opened again.
+func (um *UPSManager) Reinitialize() error {
+	if um.reopen != nil {
+		regs, err := um.reopen()
+		if err != nil {
+			return errors.Wrapf(err, "unable to open the I2C bus again")
+		}
+		um.bus.Close()
+		um.bus = regs
 	}
-	return uint16(data[0])*256 + uint16(data[1]), nil
+	if err := um.Write(regConfig, configReset); err != nil {
+		return errors.Wrapf(err, "error resetting the INA219")
+	}
+	return um.SetCalibration(um.cal)
 }
 
-func (um *UPSManager) Write(address uint8, data uint16) error {
-	if err := um.bus.WriteRegU16BE(address, data); err != nil {
-		return err
+// Health reads back the config register to make sure the INA219 still
+// answers on the bus.
+func (um *UPSManager) Health() error {
+	config, err := um.Read(regConfig)
+	if err != nil {
+		return errors.Wrapf(err, "error reading config register")
+	}
+	if config == 0 {
+		return errors.New("config register reads 0x0000, the INA219 is not configured")
 	}
 	return nil
 }
 
-func (i *UPSManager) SetCalibration16V5A() {
-	// By default we use a pretty huge range for the input voltage,
-	// which probably isn't the most appropriate choice for system
-	// that don't use a lot of power. But all of the calculations
-	// are shown below if you want to change the settings. You will
-	// also need to change any relevant register settings, such as
-	// setting the VBUS_MAX to 16V instead of 32V, etc.
-	// VBUS_MAX = 16V             (Assumes 16V, can also be set to 32V)
-	// VSHUNT_MAX = 0.08          (Assumes Gain 2, 80mV, can also be 0.32, 0.16, 0.04)
-	// RSHUNT = 0.01               (Resistor value in ohms)
-
-	// 1. Determine max possible current
-	// MaxPossible_I = VSHUNT_MAX / RSHUNT
-	// MaxPossible_I = 8.0A
-
-	// 2. Determine max expected current
-	// MaxExpected_I = 5.0A
-
-	// 3. Calculate possible range of LSBs (Min = 15-bit, Max = 12-bit)
-	// MinimumLSB = MaxExpected_I/32767
-	// MinimumLSB = 0.0001529              (61uA per bit)
-	// MaximumLSB = MaxExpected_I/4096
-	// MaximumLSB = 0,0012207              (488uA per bit)
-
-	// 4. Choose an LSB between the min and max values
-	//    (Preferrably a roundish number close to MinLSB)
-	// CurrentLSB = 0.00016 (uA per bit)
-	i.currentLSB = 0.1524 // Current LSB = 100uA per bit
-
-	// 5. Compute the calibration register
-	// Cal = trunc (0.04096 / (Current_LSB * RSHUNT))
-	// Cal = 13434 (0x347a)
-	i.calValue = 26868
-
-	// 6. Calculate the power LSB
-	// PowerLSB = 20 * CurrentLSB
-	// PowerLSB = 0.002 (2mW per bit)
-	i.powerLSB = 0.003048 // Power LSB = 2mW per bit
-
-	// 7. Compute the maximum current and shunt voltage values before overflow
-	//
-	// Max_Current = Current_LSB * 32767
-	// Max_Current = 3.2767A before overflow
-	//
-	// If Max_Current > Max_Possible_I then
-	//    Max_Current_Before_Overflow = MaxPossible_I
-	// Else
-	//    Max_Current_Before_Overflow = Max_Current
-	// End If
-	//
-	// Max_ShuntVoltage = Max_Current_Before_Overflow * RSHUNT
-	// Max_ShuntVoltage = 0.32V
-	//
-	// If Max_ShuntVoltage >= VSHUNT_MAX
-	//    Max_ShuntVoltage_Before_Overflow = VSHUNT_MAX
-	// Else
-	//    Max_ShuntVoltage_Before_Overflow = Max_ShuntVoltage
-	// End If
-
-	// 8. Compute the Maximum Power
-	// MaximumPower = Max_Current_Before_Overflow * VBUS_MAX
-	// MaximumPower = 3.2 * 32V
-	// MaximumPower = 102.4
-	i.Write(regCalibration, i.calValue)
-
-	busVoltageRange := uint16(RANGE_16V)
-	gain := uint16(DIV_2_80MV)
-	busAdcResolution := uint16(ADCRES_12BIT_32S)
-	shuntADCResolution := uint16(ADCRES_12BIT_32S)
-	mode := uint16(SANDBVOLT_CONTINUOUS)
-
-	// Set Config register to take into account the settings above
-	config := busVoltageRange<<13 |
-		gain<<11 |
-		busAdcResolution<<7 |
-		shuntADCResolution<<3 |
-		mode
-	i.Write(regConfig, config)
+func (um *UPSManager) Read(address uint8) (uint16, error) {
+	return um.bus.ReadRegister(address)
+}
+
+func (um *UPSManager) Write(address uint8, data uint16) error {
+	return um.bus.WriteRegister(address, data)
+}
+
+// SetCalibration16V5A programs the calibration of the Waveshare UPS HAT.
+func (um *UPSManager) SetCalibration16V5A() error {
+	return um.SetCalibration(Calibration16V5A)
+}
+
+// SetCalibration derives the register values from cal and writes them to
+// the calibration and config registers.
+func (um *UPSManager) SetCalibration(cal Calibration) error {
+	values, err := cal.Compute()
+	if err != nil {
+		return errors.Wrapf(err, "invalid calibration")
+	}
+	um.cal = cal
+	um.calValue = values.CalValue
+	um.currentLSB = values.CurrentLSB_mA
+	um.powerLSB = values.PowerLSB_W
+
+	if err := um.Write(regCalibration, um.calValue); err != nil {
+		return errors.Wrapf(err, "error writing calibration register")
+	}
+	if err := um.Write(regConfig, values.Config); err != nil {
+		return errors.Wrapf(err, "error writing config register")
+	}
+	return nil
 }
 
 func (um *UPSManager) GetShuntVoltage_mV() (float32, error) {
@@ -209,8 +234,8 @@ func (um *UPSManager) GetShuntVoltage_mV() (float32, error) {
 	if err != nil {
 		return 0, err
 	}
-
-	return float32(data) * 0.01, nil
+	// The shunt voltage is a two's complement value, LSB = 10uV.
+	return float32(int16(data)) * 0.01, nil
 }
 
 func (um *UPSManager) GetBusVoltage_V() (float32, error) {
@@ -230,14 +255,9 @@ func (um *UPSManager) GetCurrent_mA() (float32, error) {
 	if err != nil {
 		return 0, err
 	}
-	// This is a trick. This will be close to the max uint16 when
-	// the raspberry pi is using the battery. This means that when
-	// we substract maxInt, the value will go negative.
-	dataInt := int(data)
-	if dataInt > 32767 {
-		dataInt -= 65535
-	}
-	return float32(dataInt) * float32(um.currentLSB), nil
+	// The current is a two's complement value. It goes negative when the
+	// raspberry pi is running on the battery.
+	return float32(int16(data)) * float32(um.currentLSB), nil
 }
 
 func (um *UPSManager) GetPower_W() (float32, error) {
@@ -249,8 +269,6 @@ func (um *UPSManager) GetPower_W() (float32, error) {
 	if err != nil {
 		return 0, err
 	}
-	if data > 32767 {
-		data -= 65535
-	}
+	// Unlike the current, the power register is always positive.
 	return float32(data) * float32(um.powerLSB), nil
 }
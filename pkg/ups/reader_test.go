@@ -0,0 +1,267 @@
+package ups
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManagerCalibration(t *testing.T) {
+	emu := NewINA219Emulator(0.01)
+	manager, err := NewManagerWithRegisters(emu, Calibration16V5A)
+	require.NoError(t, err)
+
+	cal, err := emu.ReadRegister(regCalibration)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(26876), cal)
+	config, err := emu.ReadRegister(regConfig)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(0x0EEF), config)
+	assert.NoError(t, manager.Health())
+
+	_, err = NewManagerWithRegisters(NewINA219Emulator(0.01), Calibration{})
+	assert.Error(t, err)
+
+	failing := NewINA219Emulator(0.01)
+	failing.SetWriteError(errors.New("remote I/O error"))
+	_, err = NewManagerWithRegisters(failing, Calibration16V5A)
+	assert.Error(t, err)
+}
+
+func TestManagerReadings(t *testing.T) {
+	emu := NewINA219Emulator(0.01)
+	manager, err := NewManagerWithRegisters(emu, Calibration16V5A)
+	require.NoError(t, err)
+
+	tests := []struct {
+		busVoltage float64
+		current    float64
+	}{
+		{4.1, 0.5},
+		{3.9, -0.8},
+		{8.2, -2},
+		{4.2, 0},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%vV_%vA", tt.busVoltage, tt.current), func(t *testing.T) {
+			emu.SetLoad(tt.busVoltage, tt.current)
+
+			busVoltage, err := manager.GetBusVoltage_V()
+			require.NoError(t, err)
+			assert.InDelta(t, tt.busVoltage, busVoltage, 0.004)
+
+			shunt, err := manager.GetShuntVoltage_mV()
+			require.NoError(t, err)
+			assert.InDelta(t, tt.current*0.01*1000, shunt, 0.01)
+
+			current, err := manager.GetCurrent_mA()
+			require.NoError(t, err)
+			assert.InDelta(t, tt.current*1000, current, 2)
+
+			power, err := manager.GetPower_W()
+			require.NoError(t, err)
+			expected := tt.busVoltage * tt.current
+			if expected < 0 {
+				expected = -expected
+			}
+			assert.InDelta(t, expected, power, 0.02)
+		})
+	}
+}
+
+func TestManagerCurrentSign(t *testing.T) {
+	emu := NewINA219Emulator(0.1)
+	manager, err := NewManagerWithRegisters(emu, Calibration32V2A)
+	require.NoError(t, err)
+
+	// -10uV across the shunt makes the current register 0xFFFF, which is
+	// one LSB below zero and not zero.
+	emu.SetLoad(5, -0.0001)
+	current, err := manager.GetCurrent_mA()
+	require.NoError(t, err)
+	assert.InDelta(t, -0.1, current, 1e-6)
+
+	// The most negative value is -32768 LSB.
+	require.NoError(t, emu.WriteRegister(regConfig, 0x399F))
+	emu.SetLoad(5, -3.2768)
+	current, err = manager.GetCurrent_mA()
+	require.NoError(t, err)
+	assert.InDelta(t, -3200, current, 1e-3, "clipped to the 320mV range")
+}
+
+func TestManagerReadErrors(t *testing.T) {
+	emu := NewINA219Emulator(0.01)
+	manager, err := NewManagerWithRegisters(emu, Calibration16V5A)
+	require.NoError(t, err)
+
+	emu.SetReadError(errors.New("remote I/O error"))
+	_, err = manager.GetCurrent_mA()
+	assert.Error(t, err)
+	_, err = manager.GetBusVoltage_V()
+	assert.Error(t, err)
+	assert.Error(t, manager.Health())
+
+	emu.SetReadError(nil)
+	require.NoError(t, emu.WriteRegister(regConfig, 0))
+	assert.Error(t, manager.Health(), "an unconfigured INA219 is not healthy")
+}
+
+// fakeDevice answers like an I2C device that isn't an INA219.
+type fakeDevice struct{}
+
+func (fakeDevice) ReadRegister(reg byte) (uint16, error)      { return 0x1234, nil }
+func (fakeDevice) WriteRegister(reg byte, value uint16) error { return nil }
+func (fakeDevice) Close() error                               { return nil }
+
+func withDevices(t *testing.T, devices map[uint8]Registers) {
+	original := openDevice
+	openDevice = func(bus int, addr uint8) (Registers, error) {
+		if dev, ok := devices[addr]; ok {
+			return dev, nil
+		}
+		return nil, fmt.Errorf("no device at 0x%02x", addr)
+	}
+	t.Cleanup(func() { openDevice = original })
+}
+
+func TestProbe(t *testing.T) {
+	emu := NewINA219Emulator(0.01)
+	// The emulator was calibrated by a previous run, the probe must still
+	// recognize it.
+	_, err := NewManagerWithRegisters(emu, Calibration16V5A)
+	require.NoError(t, err)
+	withDevices(t, map[uint8]Registers{
+		0x43: fakeDevice{},
+		0x42: emu,
+		0x41: NewINA219Emulator(0.01),
+	})
+
+	result, err := Probe(1, nil, Calibration16V5A)
+	require.NoError(t, err)
+	assert.Equal(t, uint8(0x42), result.Address)
+	assert.Equal(t, "Waveshare UPS HAT / UPS HAT (B)", result.Board)
+	assert.Equal(t, "Waveshare UPS HAT / UPS HAT (B) at 0x42 on bus 1", result.String())
+
+	// At power on.
+	result, err = Probe(1, []uint8{0x41}, Calibration16V5A)
+	require.NoError(t, err)
+	assert.Equal(t, uint8(0x41), result.Address)
+
+	_, err = Probe(1, []uint8{0x40, 0x43}, Calibration16V5A)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "0x40: no device at 0x40")
+	assert.Contains(t, err.Error(), "0x43: config register reads 0x1234")
+}
+
+// recordingDevice fails the test if anything is written to it.
+type recordingDevice struct {
+	fakeDevice
+	t *testing.T
+}
+
+func (d recordingDevice) WriteRegister(reg byte, value uint16) error {
+	d.t.Errorf("probe wrote 0x%04x to register 0x%02x", value, reg)
+	return nil
+}
+
+func TestProbeDoesNotWrite(t *testing.T) {
+	// e.g. a PWM driver sharing the bus, which a write could reconfigure.
+	withDevices(t, map[uint8]Registers{0x40: recordingDevice{t: t}})
+	_, err := Probe(1, []uint8{0x40}, Calibration16V5A)
+	assert.Error(t, err)
+}
+
+func TestNewManagerProbes(t *testing.T) {
+	withDevices(t, map[uint8]Registers{0x41: NewINA219Emulator(0.01)})
+
+	manager, err := NewManager(ManagerConfig{Bus: 1, Calibration: Calibration16V5A})
+	require.NoError(t, err)
+	assert.Equal(t, uint8(0x41), manager.Address())
+	assert.Equal(t, "INA219 breakout (A0 bridged)", manager.Board())
+
+	_, err = NewManager(ManagerConfig{Bus: 1, Address: 0x43, Calibration: Calibration16V5A})
+	assert.Error(t, err)
+}
+
+// closableDevice fails once it is closed, like a closed I2C bus.
+type closableDevice struct {
+	*INA219Emulator
+	closed int
+}
+
+func (d *closableDevice) ReadRegister(reg byte) (uint16, error) {
+	if d.closed > 0 {
+		return 0, errors.New("bad file descriptor")
+	}
+	return d.INA219Emulator.ReadRegister(reg)
+}
+
+func (d *closableDevice) Close() error {
+	d.closed++
+	return nil
+}
+
+func TestManagerReinitialize(t *testing.T) {
+	emu := NewINA219Emulator(0.01)
+	dev := &closableDevice{INA219Emulator: emu}
+	withDevices(t, map[uint8]Registers{0x43: dev})
+	manager, err := NewManager(ManagerConfig{Bus: 1, Address: 0x43, Calibration: Calibration16V5A})
+	require.NoError(t, err)
+
+	// A brown out resets the INA219, the current reads zero.
+	require.NoError(t, emu.WriteRegister(regConfig, configReset))
+	emu.SetLoad(3.9, -0.8)
+	current, err := manager.GetCurrent_mA()
+	require.NoError(t, err)
+	assert.Zero(t, current)
+
+	// Each reinitialization opens a new handle on the bus.
+	dev = &closableDevice{INA219Emulator: emu}
+	withDevices(t, map[uint8]Registers{0x43: dev})
+	require.NoError(t, manager.Reinitialize())
+	cal, err := emu.ReadRegister(regCalibration)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(26876), cal)
+	emu.SetLoad(3.9, -0.8)
+	current, err = manager.GetCurrent_mA()
+	require.NoError(t, err)
+	assert.InDelta(t, -800, current, 2)
+
+	withDevices(t, nil)
+	assert.Error(t, manager.Reinitialize(), "the bus can't be opened again")
+	// The bus that was open is still used, and closed only once.
+	current, err = manager.GetCurrent_mA()
+	require.NoError(t, err)
+	assert.InDelta(t, -800, current, 2)
+	require.NoError(t, manager.Close())
+	assert.Equal(t, 1, dev.closed)
+}
+
+func TestReopeningSensor(t *testing.T) {
+	emu := NewINA219Emulator(0.01)
+	emu.SetWriteError(errors.New("remote I/O error"))
+	withDevices(t, map[uint8]Registers{0x43: emu})
+	open := func() (PowerSensor, error) {
+		return NewManager(ManagerConfig{Bus: 1, Address: 0x43, Calibration: Calibration16V5A})
+	}
+	_, err := open()
+	require.Error(t, err)
+
+	sensor := NewReopeningSensor(open, err)
+	_, err = sensor.GetCurrent_mA()
+	assert.Error(t, err)
+	assert.Error(t, sensor.Health())
+	assert.Error(t, sensor.Reinitialize(), "the INA219 still doesn't answer")
+
+	emu.SetWriteError(nil)
+	require.NoError(t, sensor.Reinitialize())
+	emu.SetLoad(4.1, 0.5)
+	current, err := sensor.GetCurrent_mA()
+	require.NoError(t, err)
+	assert.InDelta(t, 500, current, 2)
+	assert.NoError(t, sensor.Health())
+	assert.NoError(t, sensor.Close())
+}
@@ -0,0 +1,43 @@
+package ups
+
+import (
+	"github.com/d2r2/go-i2c"
+)
+
+// Registers gives access to the 16 bit registers of an INA219.
+type Registers interface {
+	ReadRegister(reg byte) (uint16, error)
+	WriteRegister(reg byte, value uint16) error
+	Close() error
+}
+
+// i2cRegisters reads and writes the registers over a Linux I2C bus.
+type i2cRegisters struct {
+	dev *i2c.I2C
+}
+
+// openDevice opens the registers of the device at addr on bus. Tests replace
+// it to talk to an INA219Emulator instead.
+var openDevice = func(bus int, addr uint8) (Registers, error) {
+	dev, err := i2c.NewI2C(addr, bus)
+	if err != nil {
+		return nil, err
+	}
+	return &i2cRegisters{dev: dev}, nil
+}
+
+func (r *i2cRegisters) ReadRegister(reg byte) (uint16, error) {
+	data, _, err := r.dev.ReadRegBytes(reg, 2)
+	if err != nil {
+		return 0, err
+	}
+	return uint16(data[0])<<8 | uint16(data[1]), nil
+}
+
+func (r *i2cRegisters) WriteRegister(reg byte, value uint16) error {
+	return r.dev.WriteRegU16BE(reg, value)
+}
+
+func (r *i2cRegisters) Close() error {
+	return r.dev.Close()
+}
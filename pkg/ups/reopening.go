@@ -0,0 +1,99 @@
+package ups
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ReopeningSensor stands in for a sensor that couldn't be opened, e.g.
+// because the UPS HAT didn't answer when the device booted. Reads fail until
+// Reinitialize manages to open it, after that they go to the opened sensor.
+type ReopeningSensor struct {
+	mu     sync.Mutex
+	open   func() (PowerSensor, error)
+	sensor PowerSensor
+	err    error
+}
+
+// NewReopeningSensor returns a sensor that failed to open with err and is
+// opened again with open.
+func NewReopeningSensor(open func() (PowerSensor, error), err error) *ReopeningSensor {
+	return &ReopeningSensor{open: open, err: err}
+}
+
+func (s *ReopeningSensor) get() (PowerSensor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sensor == nil {
+		return nil, fmt.Errorf("power sensor is not open: %v", s.err)
+	}
+	return s.sensor, nil
+}
+
+// Reinitialize opens the sensor, or reinitializes it once open.
+func (s *ReopeningSensor) Reinitialize() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sensor != nil {
+		if r, ok := s.sensor.(Reinitializer); ok {
+			return r.Reinitialize()
+		}
+		return nil
+	}
+	sensor, err := s.open()
+	if err != nil {
+		s.err = err
+		return err
+	}
+	s.sensor = sensor
+	return nil
+}
+
+func (s *ReopeningSensor) GetBusVoltage_V() (float32, error) {
+	sensor, err := s.get()
+	if err != nil {
+		return 0, err
+	}
+	return sensor.GetBusVoltage_V()
+}
+
+func (s *ReopeningSensor) GetShuntVoltage_mV() (float32, error) {
+	sensor, err := s.get()
+	if err != nil {
+		return 0, err
+	}
+	return sensor.GetShuntVoltage_mV()
+}
+
+func (s *ReopeningSensor) GetCurrent_mA() (float32, error) {
+	sensor, err := s.get()
+	if err != nil {
+		return 0, err
+	}
+	return sensor.GetCurrent_mA()
+}
+
+func (s *ReopeningSensor) GetPower_W() (float32, error) {
+	sensor, err := s.get()
+	if err != nil {
+		return 0, err
+	}
+	return sensor.GetPower_W()
+}
+
+func (s *ReopeningSensor) Health() error {
+	sensor, err := s.get()
+	if err != nil {
+		return err
+	}
+	return sensor.Health()
+}
+
+func (s *ReopeningSensor) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sensor == nil {
+		return nil
+	}
+	return s.sensor.Close()
+}
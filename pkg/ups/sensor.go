@@ -0,0 +1,30 @@
+package ups
+
+// PowerSensor is what the monitor needs from the power measuring hardware.
+// UPSManager implements it on top of the INA219, SimulatedSensor implements
+// it in memory so the monitor can run off a Raspberry Pi.
+type PowerSensor interface {
+	GetBusVoltage_V() (float32, error)
+	GetShuntVoltage_mV() (float32, error)
+	GetCurrent_mA() (float32, error)
+	GetPower_W() (float32, error)
+	// Health returns an error if the sensor can't be trusted anymore.
+	Health() error
+	Close() error
+}
+
+// Reinitializer is implemented by the sensors that may recover from
+// repeated failures by starting over, e.g. opening the bus again.
+type Reinitializer interface {
+	Reinitialize() error
+}
+
+var (
+	_ Reinitializer = (*UPSManager)(nil)
+	_ Reinitializer = (*ReopeningSensor)(nil)
+
+	_ PowerSensor = (*UPSManager)(nil)
+	_ PowerSensor = (*ReopeningSensor)(nil)
+	_ PowerSensor = (*SimulatedSensor)(nil)
+	_ PowerSensor = DisabledSensor{}
+)
@@ -0,0 +1,184 @@
+package ups
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// simulatedShuntOhms is the shunt used to derive the shunt voltage of
+// scripted samples. It matches the resistor on the UPS HAT.
+const simulatedShuntOhms = 0.01
+
+// Sample is a single reading returned by SimulatedSensor.
+type Sample struct {
+	BusVoltage_V    float32
+	ShuntVoltage_mV float32
+	Current_mA      float32
+	Power_W         float32
+	// Err, when set, is returned by every read while the sample is active.
+	Err error
+	// Duration is how long the sample stays active. Zero means forever.
+	Duration time.Duration
+}
+
+// NewSample builds a sample from the bus voltage and current, deriving the
+// shunt voltage and power the same way the INA219 would.
+func NewSample(busVoltage, current float32, duration time.Duration) Sample {
+	power := busVoltage * current / 1000
+	if power < 0 {
+		power = -power
+	}
+	return Sample{
+		BusVoltage_V:    busVoltage,
+		ShuntVoltage_mV: current * simulatedShuntOhms,
+		Current_mA:      current,
+		Power_W:         power,
+		Duration:        duration,
+	}
+}
+
+// SimulatedSensor is a PowerSensor that plays back a script of samples. The
+// active sample is chosen by how much time passed since the sensor was
+// created, so the monitor sees outages start and end in real time.
+type SimulatedSensor struct {
+	mu      sync.Mutex
+	samples []Sample
+	loop    bool
+	start   time.Time
+	now     func() time.Time
+	closed  bool
+}
+
+// NewSimulatedSensor creates a sensor that plays samples in order. When loop
+// is true the script starts over after the last sample, otherwise the last
+// sample is held.
+func NewSimulatedSensor(loop bool, samples ...Sample) *SimulatedSensor {
+	s := &SimulatedSensor{now: time.Now, loop: loop}
+	s.setSamples(samples)
+	return s
+}
+
+// ParseScript parses a script such as "4.1:500:2m,3.9:-800:5m" into samples.
+// Every entry is busVoltage:current_mA:duration. The duration of the last
+// entry may be omitted.
+func ParseScript(script string) ([]Sample, error) {
+	var samples []Sample
+	for _, entry := range strings.Split(script, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("invalid script entry %q, expected busVoltage:current_mA:duration", entry)
+		}
+		busVoltage, err := strconv.ParseFloat(parts[0], 32)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid bus voltage in %q", entry)
+		}
+		current, err := strconv.ParseFloat(parts[1], 32)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid current in %q", entry)
+		}
+		var duration time.Duration
+		if len(parts) == 3 {
+			duration, err = time.ParseDuration(parts[2])
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid duration in %q", entry)
+			}
+		}
+		samples = append(samples, NewSample(float32(busVoltage), float32(current), duration))
+	}
+	if len(samples) == 0 {
+		return nil, errors.New("simulated sensor script is empty")
+	}
+	return samples, nil
+}
+
+// Set replaces the script with a single sample that is held forever.
+func (s *SimulatedSensor) Set(sample Sample) {
+	sample.Duration = 0
+	s.setSamples([]Sample{sample})
+}
+
+// SetScript replaces the script and restarts playback.
+func (s *SimulatedSensor) SetScript(samples ...Sample) {
+	s.setSamples(samples)
+}
+
+func (s *SimulatedSensor) setSamples(samples []Sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samples = append([]Sample(nil), samples...)
+	s.start = s.now()
+}
+
+func (s *SimulatedSensor) current() (Sample, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return Sample{}, errors.New("simulated sensor is closed")
+	}
+	if len(s.samples) == 0 {
+		return Sample{}, errors.New("simulated sensor has no samples")
+	}
+
+	var total time.Duration
+	for _, sample := range s.samples {
+		if sample.Duration == 0 {
+			total = 0
+			break
+		}
+		total += sample.Duration
+	}
+
+	elapsed := s.now().Sub(s.start)
+	if s.loop && total > 0 {
+		elapsed %= total
+	}
+	for _, sample := range s.samples {
+		if sample.Duration == 0 || elapsed < sample.Duration {
+			return sample, sample.Err
+		}
+		elapsed -= sample.Duration
+	}
+	last := s.samples[len(s.samples)-1]
+	return last, last.Err
+}
+
+func (s *SimulatedSensor) GetBusVoltage_V() (float32, error) {
+	sample, err := s.current()
+	return sample.BusVoltage_V, err
+}
+
+func (s *SimulatedSensor) GetShuntVoltage_mV() (float32, error) {
+	sample, err := s.current()
+	return sample.ShuntVoltage_mV, err
+}
+
+func (s *SimulatedSensor) GetCurrent_mA() (float32, error) {
+	sample, err := s.current()
+	return sample.Current_mA, err
+}
+
+func (s *SimulatedSensor) GetPower_W() (float32, error) {
+	sample, err := s.current()
+	return sample.Power_W, err
+}
+
+func (s *SimulatedSensor) Health() error {
+	_, err := s.current()
+	return err
+}
+
+func (s *SimulatedSensor) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
@@ -0,0 +1,77 @@
+package ups
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseScript(t *testing.T) {
+	samples, err := ParseScript("4.1:500:2m, 3.9:-800:5m,4.0:0")
+	require.NoError(t, err)
+	require.Len(t, samples, 3)
+
+	assert.InDelta(t, 4.1, samples[0].BusVoltage_V, 0.001)
+	assert.InDelta(t, 500, samples[0].Current_mA, 0.001)
+	assert.Equal(t, 2*time.Minute, samples[0].Duration)
+	assert.InDelta(t, -800, samples[1].Current_mA, 0.001)
+	assert.InDelta(t, 3.12, samples[1].Power_W, 0.001)
+	assert.Equal(t, time.Duration(0), samples[2].Duration)
+
+	_, err = ParseScript("")
+	assert.Error(t, err)
+	_, err = ParseScript("4.1")
+	assert.Error(t, err)
+	_, err = ParseScript("4.1:abc:1m")
+	assert.Error(t, err)
+}
+
+func TestSimulatedSensorPlaysScript(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sensor := NewSimulatedSensor(false)
+	sensor.now = func() time.Time { return now }
+	sensor.SetScript(
+		NewSample(4.1, 500, time.Minute),
+		NewSample(3.9, -800, time.Minute),
+	)
+
+	current, err := sensor.GetCurrent_mA()
+	require.NoError(t, err)
+	assert.InDelta(t, 500, current, 0.001)
+
+	now = now.Add(90 * time.Second)
+	current, err = sensor.GetCurrent_mA()
+	require.NoError(t, err)
+	assert.InDelta(t, -800, current, 0.001)
+
+	// Without looping the last sample is held.
+	now = now.Add(time.Hour)
+	voltage, err := sensor.GetBusVoltage_V()
+	require.NoError(t, err)
+	assert.InDelta(t, 3.9, voltage, 0.001)
+}
+
+func TestSimulatedSensorLoopsAndFails(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sensor := NewSimulatedSensor(true)
+	sensor.now = func() time.Time { return now }
+	sensor.SetScript(
+		NewSample(4.1, 500, time.Minute),
+		Sample{Err: errors.New("i2c timeout"), Duration: time.Minute},
+	)
+
+	now = now.Add(2*time.Minute + 10*time.Second)
+	require.NoError(t, sensor.Health())
+
+	now = now.Add(time.Minute)
+	_, err := sensor.GetCurrent_mA()
+	assert.EqualError(t, err, "i2c timeout")
+	assert.Error(t, sensor.Health())
+
+	require.NoError(t, sensor.Close())
+	sensor.Set(NewSample(4.1, 500, 0))
+	assert.Error(t, sensor.Health())
+}
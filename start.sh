@@ -17,4 +17,6 @@ export DBUS_SYSTEM_BUS_ADDRESS=unix:path=/host/run/dbus/system_bus_socket
 # fi
 # Test
 
-./poweroutage
\ No newline at end of file
+# exec so the monitor receives the SIGTERM Balena sends on updates and can
+# shut down cleanly.
+exec ./poweroutage
\ No newline at end of file